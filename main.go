@@ -1,339 +1,237 @@
 package main
 
 import (
-	"errors"
+	"context"
+	"flag"
 	"fmt"
 	"io"
-	"net"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
-)
 
-const (
-	maxIOrequestBufSize = 1024
-	maxDataBufSize = 512
-	maxDataBlockSize = 520
+	"github.com/nalapati/gotftp/tftp"
 )
 
-type Config interface {
-	GetFSRoot() string
-	GetFSTmp() string
-	GetTftpIP() string
-	GetTftpPort() int
-}
-
-type TftpConfig struct {
-	fsroot string
-	fstmp string
-	ip string
-	port int
-}
-
-func (t TftpConfig) GetFSRoot() string {
-	return t.fsroot
-}
+//abortTimeout is how long the transfers aborted at the drain deadline are waited
+//for before the server exits.
+const abortTimeout = 2 * time.Second
 
-func (t TftpConfig) GetFSTmp() string {
-	return t.fstmp
+//hookFlag adds the commands of a repeated flag to hooks as validators, or as
+//observers.
+type hookFlag struct {
+	hooks *tftp.Hooks
+	observer bool
+	commands []string
 }
 
-func (t TftpConfig) GetTftpIP() string {
-	return t.ip
-}
+func (h *hookFlag) Set(command string) error {
+	if h.observer {
+		h.hooks.Observe(tftp.CommandObserver(command))
+	} else {
+		h.hooks.Validate(tftp.CommandValidator(command))
+	}
 
-func (t TftpConfig) GetTftpPort() int {
-	return t.port
+	h.commands = append(h.commands, command)
+	return nil
 }
 
-type Connection interface {
-	WriteTo([]byte) (numBytes int, err error)
-	ReadFrom([]byte) (numBytes int, err error)
-}
+func (h *hookFlag) String() string {
+	if h == nil {
+		return ""
+	}
 
-type UDPConnection struct {
-	addr net.Addr
-	conn *net.UDPConn
-	writeTimeout uint64
-	readTimeout uint64
+	return strings.Join(h.commands, ",")
 }
 
-func (u *UDPConnection) WriteTo(buf []byte) (numBytes int, err error) {
-	u.conn.SetWriteDeadline(time.Now().Add(time.Duration(u.writeTimeout)))
-	numBytes, err = u.conn.WriteTo(buf, u.addr)
-	return numBytes, err
+//Flags holds the command line options of the server.
+type Flags struct {
+	set *flag.FlagSet
+
+	timeout *time.Duration
+	retries *int
+	maxUploadSize *int64
+	blockSize *int
+	windowSize *int
+	rollover *uint
+	symlinks *string
+	rules *string
+	acl *string
+	mode *string
+	upload *string
+	backups *bool
+	quotas *tftp.Quotas
+	hooks *tftp.Hooks
+	memory *bool
+	drain *time.Duration
+	workers *int
+	maxSessions *int
+	maxPerClient *int
+	ports *string
 }
 
-func (u *UDPConnection) ReadFrom(buf []byte) (numBytes int, err error) {
-	u.conn.SetReadDeadline(time.Now().Add(time.Duration(u.readTimeout)))
-	numBytes, _, err = u.conn.ReadFrom(buf)
-	return numBytes, err
-}
+func NewFlags(output io.Writer) *Flags {
+	set := flag.NewFlagSet("gotftp", flag.ContinueOnError)
+	set.SetOutput(output)
+
+	f := &Flags{set: set, quotas: tftp.NewQuotas(), hooks: tftp.NewHooks()}
+	f.timeout = set.Duration("timeout", tftp.DefaultTimeout, "initial retransmission timeout")
+	f.retries = set.Int("retries", tftp.DefaultRetries, "retransmissions of a packet before a transfer is abandoned")
+	f.maxUploadSize = set.Int64("maxupload", 0, "largest file in bytes a client may write, 0 for no limit")
+	f.blockSize = set.Int("blksize", tftp.DefaultMaxBlockSize, "largest block size negotiated with the blksize option")
+	f.windowSize = set.Int("windowsize", tftp.DefaultMaxWindowSize, "largest window size negotiated with the windowsize option")
+	f.rollover = set.Uint("rollover", 0, "block number that follows block 65535, 0 or 1")
+	f.symlinks = set.String("symlinks", "root", "symlinks under the file system root that are followed: root for those that stay under the root, deny or follow")
+	f.rules = set.String("rules", "", "file of rules filenames are rewritten with before they are looked up")
+	f.acl = set.String("acl", "", "access control list file of the clients allowed to read and write files")
+	f.mode = set.String("mode", "readwrite", "requests accepted: readwrite, readonly or writeonly")
+	f.upload = set.String("upload", "overwrite", "what an upload does when the file exists: overwrite, noclobber to refuse it or create to also refuse new directories")
+	f.backups = set.Bool("backups", false, "keep a file an upload overwrites under a timestamped name")
+	set.Var(f.quotas, "quota", "bytes the clients in a network may upload as <cidr>=<bytes>, may be repeated")
+	set.Var(&hookFlag{hooks: f.hooks}, "validate", "command run by sh with an upload on stdin before it is stored, a non zero exit rejects it, may be repeated")
+	set.Var(&hookFlag{hooks: f.hooks, observer: true}, "notify", "command run by sh once an upload is stored, may be repeated")
+	f.memory = set.Bool("memory", false, "serve files from memory, the file system root is loaded at startup and uploads are not written to disk")
+	f.workers = set.Int("workers", 0, "goroutines sessions are served on, requests are refused while every one is busy, 0 for a goroutine per session")
+	f.maxSessions = set.Int("maxsessions", tftp.DefaultMaxSessions, "sessions served at once, 0 for no limit")
+	f.maxPerClient = set.Int("maxperclient", 0, "sessions served at once for each client ip, 0 for no limit")
+	f.ports = set.String("ports", "", "range of ports transfers are served from as <first>-<last>, by default ports the system picks")
+	f.drain = set.Duration("drain", 30 * time.Second, "time transfers in progress are given to finish on SIGINT or SIGTERM before they are aborted")
+
+	set.Usage = func() {
+		fmt.Fprintln(output, "./main [options] <file system root> <file system tmp> <interface ip> <port>")
+		set.PrintDefaults()
+	}
 
-type Session struct {
-	connection *UDPConnection
-	ioRequest IORequest
+	return f
 }
 
-/*
-Read State Machine:
-
-1. Incoming Connection.
-2. Read Request contains file name / mode.
-3. Send DataBlockNumber i.
-4. Receive Ack DataBlockNumber i. On timeout re-send DataBlockNumber i. if retries > x, send error, close conn.
-5. If remaining data, Goto Step 3, else exit.
-*/
-func ProcessReadRequest(conn Connection, readRequest IORequest, config Config) error {
-
-	dataBlockNumber := uint16(1)
-
-	dataBuf := make([]byte, maxDataBufSize)
-	dataBlockBuf := make([]byte, maxDataBlockSize)
-
-	ackBuf := make([]byte, 4)
-
-	file, err := os.Open(fmt.Sprintf("%s%s", config.GetFSRoot(), readRequest.filename))
+//Configure parses the command line in args and returns the server it describes.
+//The file system root and tmp directories are created if they do not exist.
+func (f *Flags) Configure(args []string) (*tftp.Server, error) {
+	err := f.set.Parse(args)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	defer file.Close()
-
-	for {
-		numBytes, err := file.ReadAt(dataBuf, int64((dataBlockNumber-1) * maxDataBufSize))
-		if err != nil && err != io.EOF {
-			return err
-		}
-
-		dataBlock := DataBlock{dataBlockNumber, dataBuf[:numBytes]}
-		numBytes = DataBlockToSlice(dataBlock, dataBlockBuf)
-
-		_, err = conn.WriteTo(dataBlockBuf[:numBytes])
-
-		if err != nil {
-			return err
-		}
-
-		_, err = conn.ReadFrom(ackBuf)
-
-		if err != nil {
-			return err
-		}
-
-		ack, err := ParseAck(ackBuf)
-		if err != nil {
-			return err
-		}
-
-		if ack.blockNumber != uint16(dataBlockNumber) {
-			return errors.New(fmt.Sprintf("expected datablock %d got %d", dataBlockNumber, ack.blockNumber))
-		}
-
-		dataBlockNumber = dataBlockNumber+1
-
-		if numBytes < 516 {
-			break
-		}
+	if f.set.NArg() < 4 {
+		return nil, fmt.Errorf("expected 4 arguments got %d", f.set.NArg())
 	}
 
-	fmt.Println("read complete! ", readRequest.filename)
-	return nil
-}
-
-/*
-Write State Machine:
-
-1. Incoming Connection.
-2. Write Request contains file name / mode.
-3. Send Ack DataBlockNumber i.
-4. Receive DataBlockNumber i+1. On timeout re-send Ack DataBlockNumber i. if retries > x, send error, close conn.
-5. If datablock length < 512, Goto step 3 and exit, else Goto Step 3 and repeat.
-*/
-func ProcessWriteRequest(conn Connection, writeRequest IORequest, config Config) error {
+	if *f.retries < 0 || *f.retries > tftp.MaxRetries {
+		return nil, fmt.Errorf("retries must be between 0 and %d", tftp.MaxRetries)
+	}
 
-	dataBlockNumber := uint16(0)
+	if *f.blockSize < tftp.MinBlockSize || *f.blockSize > tftp.MaxBlockSize {
+		return nil, fmt.Errorf("blksize must be between %d and %d", tftp.MinBlockSize, tftp.MaxBlockSize)
+	}
 
-	dataBlockBuf := make([]byte, maxDataBlockSize)
+	if *f.windowSize < tftp.MinWindowSize || *f.windowSize > tftp.MaxWindowSize {
+		return nil, fmt.Errorf("windowsize must be between %d and %d", tftp.MinWindowSize, tftp.MaxWindowSize)
+	}
 
-	ackBuf := make([]byte, 4)
+	if *f.maxUploadSize < 0 {
+		return nil, fmt.Errorf("maxupload must not be negative")
+	}
 
-	final := false
+	if *f.workers < 0 || *f.maxSessions < 0 || *f.maxPerClient < 0 {
+		return nil, fmt.Errorf("workers, maxsessions and maxperclient must not be negative")
+	}
 
-	file, err := os.Create(fmt.Sprintf("%s/%s", config.GetFSTmp(), writeRequest.filename))
-	defer file.Close()
+	if *f.rollover > 1 {
+		return nil, fmt.Errorf("rollover must be 0 or 1")
+	}
 
+	symlinkPolicy, err := tftp.ParseSymlinkPolicy(*f.symlinks)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	for {
-		ack := Ack{dataBlockNumber}
-		AckToSlice(ack, ackBuf)
-
-		numBytes, err := conn.WriteTo(ackBuf)
+	serverMode, err := tftp.ParseServerMode(*f.mode)
+	if err != nil {
+		return nil, err
+	}
 
-		dataBlockNumber = dataBlockNumber+1
+	uploadPolicy, err := tftp.ParseUploadPolicy(*f.upload)
+	if err != nil {
+		return nil, err
+	}
 
-		if err != nil {
-			return err
-		}
+	root := f.set.Arg(0)
+	tmp := f.set.Arg(1)
 
-		if numBytes != 4 {
-			return errors.New("unable to write complete ack response")
-		}
+	port, err := strconv.Atoi(f.set.Arg(3))
+	if err != nil {
+		return nil, fmt.Errorf("port %s is not a number", f.set.Arg(3))
+	}
 
-		if final {
-			break
-		}
+	options := []tftp.Option{
+		tftp.WithAddress(f.set.Arg(2), port),
+		tftp.WithFileSystem(root, tmp),
+		tftp.WithTimeout(*f.timeout),
+		tftp.WithRetries(*f.retries),
+		tftp.WithMaxBlockSize(*f.blockSize),
+		tftp.WithMaxWindowSize(*f.windowSize),
+		tftp.WithBlockRollover(uint16(*f.rollover)),
+		tftp.WithSymlinkPolicy(symlinkPolicy),
+		tftp.WithMode(serverMode),
+		tftp.WithUploadPolicy(uploadPolicy, *f.backups),
+		tftp.WithMaxUploadSize(*f.maxUploadSize),
+		tftp.WithQuotas(f.quotas),
+		tftp.WithHooks(f.hooks),
+		tftp.WithWorkers(*f.workers),
+		tftp.WithMaxSessions(*f.maxSessions, *f.maxPerClient),
+	}
 
-		numBytes, err = conn.ReadFrom(dataBlockBuf)
-		if err != nil {
-			return err
+	for _, dir := range []string{root, tmp} {
+		dirExists, _ := Exists(dir)
+		if !dirExists {
+			err := os.MkdirAll(dir, os.ModeDir | 0777)
+			if err != nil {
+				return nil, err
+			}
 		}
+	}
 
-		dataBlock, err := ParseDataBlock(dataBlockBuf[:numBytes])
+	if *f.rules != "" {
+		rules, err := tftp.LoadRewriteRules(*f.rules)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		if dataBlock.blockNumber != uint16(dataBlockNumber) {
-			return errors.New(fmt.Sprintf("expected datablock %d got %d", dataBlockNumber, dataBlock.blockNumber))
-		}
+		options = append(options, tftp.WithRewriteRules(rules))
+	}
 
-		numBytes, err = file.Write(dataBlock.data)
+	if *f.acl != "" {
+		acl, err := tftp.LoadACL(*f.acl)
 		if err != nil {
-			return err
-		}
-
-		if dataBlock.IsFinal() {
-			final = true
-			file.Close()
-
-			oldFilename := fmt.Sprintf("%s/%s", config.GetFSTmp(), writeRequest.filename)
-			newFilename := fmt.Sprintf("%s/%s", config.GetFSRoot(), writeRequest.filename)
-			fmt.Printf("renaming %s to %s\n", oldFilename, newFilename)
-			os.Rename(oldFilename, newFilename)
+			return nil, err
 		}
 
+		options = append(options, tftp.WithACL(acl))
 	}
 
-	fmt.Println("transfer complete! ", writeRequest.filename)
-	return nil
-}
-
-//HandleConnection dequeues a session from the session channel and processes
-//the IORequest corresponding to the session.
-func HandleConnection(sessions chan* Session, config Config, run *bool) {
-
-	error := make([]byte, 48)
-	errorLength := ToTftpErrorSlice(TftpError{0, "illegal request"}, error)
-
-
-	for session := range sessions {
-
-		if (session.ioRequest.isWrite) {
-			err := ProcessWriteRequest(session.connection, session.ioRequest, config)
-			if err != nil {
-				fmt.Println(err)
-				session.connection.WriteTo(error[:errorLength])
-			}
-		} else {
-			err := ProcessReadRequest(session.connection, session.ioRequest, config)
-			if err != nil {
-				fmt.Println(err)
-				session.connection.WriteTo(error[:errorLength])
-			}
+	if *f.ports != "" {
+		ports, err := tftp.ParsePortRange(*f.ports)
+		if err != nil {
+			return nil, err
 		}
 
-		if (!*run) {
-			break
-		}
+		options = append(options, tftp.WithPortRange(ports))
 	}
 
-}
-
-//UDPServer starts a UDP server on the specified ip and port and passes received
-//connections to the connections channel. If the UDP server receives more connections
-//than it can handle it sends an error message to the client and closes the connection.
-func UDPServer(sessions chan* Session, config Config, run *bool) {
-	fmt.Println("starting UDP Server on ", config.GetTftpIP(), config.GetTftpPort(), *run)
-
-	ioRequestBuf := make([]byte, maxIOrequestBufSize)
-
-	error := make([]byte, 48)
-	errorLength := ToTftpErrorSlice(TftpError{0, "illegal request"}, error)
-
-	addr := net.UDPAddr{
-		Port: config.GetTftpPort(),
-		IP: net.ParseIP(config.GetTftpIP()),
-	}
-
-	for (*run) {
-		conn, err := net.ListenUDP("udp", &addr)
+	if *f.memory {
+		backend := tftp.NewMemoryBackend()
+		err = backend.LoadDir(root)
 		if err != nil {
-			fmt.Println("error occurred while listening for udp connections", err)
-			conn.Close()
-
-			continue
+			return nil, err
 		}
 
-		for {
-			numBytes, addr, err := conn.ReadFrom(ioRequestBuf)
-			if err != nil {
-				fmt.Println("error while reading from the tftp listener port")
-				conn.Close()
-				break
-			}
-
-			addrServ := net.UDPAddr{
-				Port: 0,
-				IP: net.ParseIP(config.GetTftpIP()),
-			}
-
-			connServ, err := net.ListenUDP("udp", &addrServ)
-			if err != nil {
-				fmt.Println("error occurred while listening on udp child socket for ", addr)
-				connServ.Close()
-				conn.Close()
-
-				break
-			}
-
-			connection := &UDPConnection{addr, connServ, 8e9, 8e9}
-
-			ioRequest, err := ParseIORequest(ioRequestBuf[:numBytes])
-			if err != nil {
-				fmt.Println(err, addr, ioRequest.filename)
-				connServ.WriteTo(error[:errorLength], addr)
-				connServ.Close()
-
-				continue
-			}
-
-			session := &Session{connection, ioRequest}
-
-			select {
-			case sessions <- session:
-				fmt.Printf("Processing session for remote: %s, local: %s, filename: %s, write: %v, mode: %s\n", addr, connServ.LocalAddr(), ioRequest.filename, ioRequest.isWrite, ioRequest.mode)
-			default:
-				fmt.Println("Rejecting session for remote: %s, local: %s, filename: %s, write: %v, mode: %s\n", addr, connServ.LocalAddr(), ioRequest.filename, ioRequest.isWrite, ioRequest.mode)
-				go func() {
-					connServ.WriteTo(error[:errorLength], addr)
-					connServ.Close()
-				}()
-			}
-
-			if !(*run) {
-				break
-			}
-		}
+		options = append(options, tftp.WithBackend(backend))
 	}
-}
 
-func Usage(val int) {
-	fmt.Println("./main <file system root> <file system tmp> <interface ip> <port>")
-	os.Exit(val)
+	return tftp.NewServer(options...), nil
 }
 
 // Exists returns whether the given file or directory exists or not
@@ -345,38 +243,47 @@ func Exists(path string) (bool, error) {
 }
 
 func main() {
-	if len(os.Args) < 4 {
-		Usage(1)
+	flags := NewFlags(os.Stderr)
+
+	server, err := flags.Configure(os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(2)
 	}
 
-	run := true
-	port, err := strconv.Atoi(os.Args[4])
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		flags.set.Usage()
+		os.Exit(1)
 	}
 
-	config := TftpConfig{fsroot:os.Args[1], fstmp:os.Args[2], ip:os.Args[3], port:port}
-
-	dirExists, _ := Exists(config.GetFSRoot())
-	if !dirExists {
-		err := os.MkdirAll(config.GetFSRoot(), os.ModeDir | 0777)
-		if err != nil {
-			panic(err)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ListenAndServe()
+	}()
+
+	select {
+	case err = <-served:
+	case <-ctx.Done():
+		stop()
+		tftp.DefaultLogger.Printf("shutting down, waiting up to %s for the transfers in progress", *flags.drain)
+
+		drain, cancel := context.WithTimeout(context.Background(), *flags.drain)
+		if server.Shutdown(drain) != nil {
+			// the aborted transfers are given a moment to discard their uploads
+			abort, cancelAbort := context.WithTimeout(context.Background(), abortTimeout)
+			server.Shutdown(abort)
+			cancelAbort()
 		}
-	}
+		cancel()
 
-	dirExists, _ = Exists(config.GetFSTmp())
-	if !dirExists {
-		err := os.MkdirAll(config.GetFSTmp(), os.ModeDir | 0777)
-		if err != nil {
-			panic(err)
-		}
+		err = <-served
 	}
 
-	sessions := make(chan *Session, 100)
-
-	for i:=0; i<10; i++ {
-		go HandleConnection(sessions, config, &run)
+	if err != nil && err != tftp.ErrServerClosed {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	UDPServer(sessions, config, &run)
 }
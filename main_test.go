@@ -1,204 +1,113 @@
 package main
 
 import (
-	"bytes"
+	"fmt"
 	"io"
-	"testing"
 	"os"
-	"fmt"
-	"hash/crc32"
-	"io/ioutil"
-)
-
-type MockConnection struct {
-	file *os.File
-	t *testing.T
-
-	input []byte
-	output []byte
-	inputLength int
-	outputLength int
-
-	err error
-	handle func(*testing.T, *os.File, []byte, []byte) (int)
-}
-
-func (m *MockConnection) WriteTo(bytes []byte) (numBytes int, err error) {
-	if m.err != nil {
-		return 0, m.err
-	}
-
-	copy(m.output, bytes)
-	m.outputLength = len(bytes)
-
-	return m.outputLength, nil
-}
-
-func (m *MockConnection) ReadFrom(bytes []byte) (numBytes int, err error) {
-	if m.err != nil {
-		return 0, m.err
-	}
-
-	numBytes = m.handle(m.t, m.file, m.output[:m.outputLength], m.input)
-	copy(bytes, m.input[:numBytes])
+	"path/filepath"
+	"testing"
+	"time"
 
-	return numBytes, nil
-}
+	"github.com/nalapati/gotftp/tftp"
+)
 
-func InitTest(config Config) {
-	dirExists, _ := Exists(config.GetFSRoot())
-	if !dirExists {
-		err := os.Mkdir(config.GetFSRoot(), os.ModeDir | 0777)
-		if err != nil {
-			panic(err)
-		}
-	}
+func TestConfigure(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "root")
+	tmp := filepath.Join(t.TempDir(), "tmp")
 
-	dirExists, _ = Exists(config.GetFSTmp())
-	if !dirExists {
-		err := os.Mkdir(config.GetFSTmp(), os.ModeDir | 0777)
-		if err != nil {
-			panic(err)
-		}
-	}
-}
+	args := []string{"-timeout", "3s", "-retries", "2", "-blksize", "1024", "-mode", "readonly", "-upload", "noclobber", "-quota", "10.0.0.0/8=1000", "-drain", "5s", "-workers", "8", "-ports", "50000-50100", root, tmp, "127.0.0.1", "6969"}
 
-func CloseTest(config Config) {
-	err := os.RemoveAll(config.GetFSRoot())
+	flags := NewFlags(io.Discard)
+	server, err := flags.Configure(args)
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
 	}
 
-	err = os.RemoveAll(config.GetFSTmp())
-	if err != nil {
-		panic(err)
+	if *flags.drain != 5 * time.Second {
+		t.Error(fmt.Sprintf("expected a drain deadline of 5s got %s", *flags.drain))
 	}
-}
-
-func TestProcessReadRequest(t *testing.T) {
-	config := TftpConfig{"/tmp/fsroot/", "/tmp/fstmp/", "127.0.0.1", 8000}
-
-	InitTest(config)
-	defer CloseTest(config)
 
-	ioRequest := IORequest{isWrite:false, filename:"test.txt", mode:"octet"}
-
-	fname := fmt.Sprintf("%s%s", config.GetFSRoot(),ioRequest.filename)
-	CreateTestFile(fname, 512*5+256)
-
-	file, err := os.Open(fname)
-	if err != nil {
-		t.Error(err)
+	config := server.Config()
+	if config.GetFSRoot() != root || config.GetFSTmp() != tmp || config.GetTftpIP() != "127.0.0.1" || config.GetTftpPort() != 6969 {
+		t.Error(fmt.Sprintf("unexpected address or file system %s %s %s %d", config.GetFSRoot(), config.GetFSTmp(), config.GetTftpIP(), config.GetTftpPort()))
 	}
 
-	connection := &MockConnection{file, t, make([]byte, 520), make([]byte, 520), 0, 0, nil, ReadHandler}
-
-	err = ProcessReadRequest(connection, ioRequest, config)
-	if err != nil {
-		t.Error(err)
+	if config.GetTimeout() != 3 * time.Second || config.GetRetries() != 2 || config.GetMaxBlockSize() != 1024 {
+		t.Error(fmt.Sprintf("unexpected transfer settings %s %d %d", config.GetTimeout(), config.GetRetries(), config.GetMaxBlockSize()))
 	}
 
-	file.Close()
-}
-
-func ReadHandler(t *testing.T, f *os.File, dataBlockBytes []byte, ackBytes []byte) int {
-
-	dataBlock, err := ParseDataBlock(dataBlockBytes)
-	if err != nil {
-		t.Error(err)
+	if config.GetMode() != tftp.ReadOnly || config.GetUploadPolicy() != tftp.UploadNoClobber {
+		t.Error("expected the mode and upload policy of the flags")
 	}
 
-	buf := make([]byte, 512)
-	numBytes, err:= f.ReadAt(buf, int64(dataBlock.blockNumber-1)*512)
-	if err != nil && err != io.EOF {
-		t.Error(err)
+	if config.GetWorkers() != 8 || config.GetScheduler() == nil {
+		t.Error(fmt.Sprintf("expected 8 workers and a scheduler got %d %v", config.GetWorkers(), config.GetScheduler()))
 	}
 
-	if bytes.Compare(dataBlock.data, buf[:numBytes]) != 0 {
-		t.Error("file bytes doesn't match the data block returned")
+	if config.GetPortRange().String() != "50000-50100" {
+		t.Error(fmt.Sprintf("expected the port range of the flags got %s", config.GetPortRange()))
 	}
 
-	ack := Ack{dataBlock.blockNumber}
-	AckToSlice(ack, ackBytes)
-
-	return 4
-}
-
-func CreateTestFile(filename string, length int) {
-	file, err := os.Create(filename)
-	defer file.Close()
-
-	if err != nil {
-		panic(err)
+	if config.GetQuotas().String() != "10.0.0.0/8=1000" {
+		t.Error(fmt.Sprintf("expected the quota of the flags got %s", config.GetQuotas()))
 	}
 
-	buf := make([]byte, length)
-	for i:=0; i<len(buf); i=i+1 {
-		buf[i] = byte(i)
+	for _, dir := range []string{root, tmp} {
+		if _, err := os.Stat(dir); err != nil {
+			t.Error(fmt.Sprintf("expected %s to be created got %s", dir, err))
+		}
 	}
-
-	file.Write(buf)
 }
 
-func TestProcessWriteRequest(t *testing.T) {
-	config := TftpConfig{"/tmp/fsroot/", "/tmp/fstmp/", "127.0.0.1", 8000}
-
-	InitTest(config)
-	defer CloseTest(config)
-
-	ioRequest := IORequest{isWrite:true, filename:"test.txt", mode:"octet"}
-
-	fname := fmt.Sprintf("%s%s", config.GetFSTmp(), "test-expected.txt")
-	CreateTestFile(fname, 512*5+256)
-
-	file, err := os.Open(fname)
+func TestConfigureMemory(t *testing.T) {
+	root := t.TempDir()
+	err := os.WriteFile(filepath.Join(root, "boot.img"), []byte("boot"), 0644)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 
-	connection := &MockConnection{file, t, make([]byte, 520), make([]byte, 520), 0, 0, nil, WriteHandler}
-
-	err = ProcessWriteRequest(connection, ioRequest, config)
+	server, err := NewFlags(io.Discard).Configure([]string{"-memory", root, t.TempDir(), "127.0.0.1", "69"})
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 
-	file.Close()
-
-	hashExpected, _ := GetHash(fname)
-	hashActual, _ := GetHash(fmt.Sprintf("%s%s", config.GetFSRoot(), "test.txt"))
-
-	if hashExpected != hashActual {
-		t.Error("files mismatched while writing")
+	backend, ok := server.Config().GetBackend().(*tftp.MemoryBackend)
+	if !ok {
+		t.Fatal("expected a memory backend")
 	}
-}
 
-func WriteHandler(t *testing.T, f *os.File, ackBytes []byte, dataBlockBytes []byte) int {
-
-	ack, err := ParseAck(ackBytes)
-	if err != nil {
-		t.Error(err)
+	if data, ok := backend.Load("boot.img"); !ok || string(data) != "boot" {
+		t.Error("expected the file system root to be loaded")
 	}
-
-	buf := make([]byte, 512)
-	numBytes, err:= f.ReadAt(buf, int64(ack.blockNumber)*512)
-	if err != nil && err != io.EOF {
-		t.Error(err)
-	}
-
-	dataBlock := DataBlock{ack.blockNumber+1, buf[:numBytes]}
-	numBytes = DataBlockToSlice(dataBlock, dataBlockBytes)
-
-	return numBytes
-
 }
 
-func GetHash(filename string) (uint32, error) {
-	bs, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return 0, err
+func TestConfigureErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	cases := [][]string{
+		{dir, dir, "127.0.0.1"},
+		{dir, dir, "127.0.0.1", "tftp"},
+		{"-retries", "-1", dir, dir, "127.0.0.1", "69"},
+		{"-retries", "33", dir, dir, "127.0.0.1", "69"},
+		{"-blksize", "4", dir, dir, "127.0.0.1", "69"},
+		{"-windowsize", "0", dir, dir, "127.0.0.1", "69"},
+		{"-rollover", "2", dir, dir, "127.0.0.1", "69"},
+		{"-symlinks", "sometimes", dir, dir, "127.0.0.1", "69"},
+		{"-mode", "appendonly", dir, dir, "127.0.0.1", "69"},
+		{"-upload", "replace", dir, dir, "127.0.0.1", "69"},
+		{"-maxupload", "-1", dir, dir, "127.0.0.1", "69"},
+		{"-quota", "10.0.0.0/8", dir, dir, "127.0.0.1", "69"},
+		{"-acl", filepath.Join(dir, "missing"), dir, dir, "127.0.0.1", "69"},
+		{"-workers", "-1", dir, dir, "127.0.0.1", "69"},
+		{"-maxperclient", "-1", dir, dir, "127.0.0.1", "69"},
+		{"-ports", "50100-50000", dir, dir, "127.0.0.1", "69"},
+		{"-unknown", dir, dir, "127.0.0.1", "69"},
+	}
+
+	for _, args := range cases {
+		_, err := NewFlags(io.Discard).Configure(args)
+		if err == nil {
+			t.Error(fmt.Sprintf("expected an error for %v", args))
+		}
 	}
-	h := crc32.NewIEEE()
-	h.Write(bs)
-	return h.Sum32(), nil
 }
@@ -0,0 +1,193 @@
+package tftp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strings"
+)
+
+/*
+Access control list file, one rule per line, blank lines and lines starting with
+# are ignored:
+
+	allow|deny read|write|any <cidr>|* <glob>
+
+The first rule matching the client address, the operation and the filename
+decides whether a request is allowed, a request no rule matches is denied. The
+glob is matched with path.Match after filenames are rewritten, * does not match
+a /. A glob of ** matches every filename and one ending in /** every filename
+under a directory.
+*/
+
+type ACLRule struct {
+	line int
+	allow bool
+	read bool
+	write bool
+	network *net.IPNet
+	glob string
+}
+
+//ACL decides which clients may read and write which files. A nil ACL allows
+//every request.
+type ACL struct {
+	rules []ACLRule
+}
+
+//LoadACL parses the access control list file at filename.
+func LoadACL(filename string) (*ACL, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	return ParseACL(file)
+}
+
+//ParseACL parses the access control list read from reader.
+func ParseACL(reader io.Reader) (*ACL, error) {
+	acl := &ACL{}
+	scanner := bufio.NewScanner(reader)
+
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		rule, err := parseACLRule(line, fields)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("acl rule on line %d: %s", line, err))
+		}
+
+		acl.rules = append(acl.rules, rule)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return acl, nil
+}
+
+func parseACLRule(line int, fields []string) (ACLRule, error) {
+	rule := ACLRule{line: line}
+
+	if len(fields) != 4 {
+		return rule, errors.New("expected allow|deny read|write|any <cidr> <glob>")
+	}
+
+	switch fields[0] {
+	case "allow":
+		rule.allow = true
+	case "deny":
+	default:
+		return rule, errors.New(fmt.Sprintf("unknown action %s", fields[0]))
+	}
+
+	switch fields[1] {
+	case "read":
+		rule.read = true
+	case "write":
+		rule.write = true
+	case "any":
+		rule.read = true
+		rule.write = true
+	default:
+		return rule, errors.New(fmt.Sprintf("unknown operation %s", fields[1]))
+	}
+
+	if fields[2] != "*" {
+		_, network, err := net.ParseCIDR(fields[2])
+		if err != nil {
+			return rule, err
+		}
+
+		rule.network = network
+	}
+
+	_, err := path.Match(fields[3], "")
+	if err != nil {
+		return rule, err
+	}
+
+	rule.glob = fields[3]
+	return rule, nil
+}
+
+//Check returns nil if the client at addr may read or write filename, otherwise
+//a TftpError with code 2. Denied requests are logged to logger.
+func (a *ACL) Check(filename string, isWrite bool, addr net.Addr, logger Logger) error {
+	if a == nil {
+		return nil
+	}
+
+	if cleaned, err := CleanFilename(filename); err == nil {
+		filename = cleaned
+	}
+
+	operation := "read"
+	if isWrite {
+		operation = "write"
+	}
+
+	ip := AddrIP(addr)
+
+	for _, rule := range a.rules {
+		if !rule.matches(filename, isWrite, ip) {
+			continue
+		}
+
+		if rule.allow {
+			return nil
+		}
+
+		logger.Printf("acl denied %s of %q for remote: %s by the rule on line %d", operation, filename, addr, rule.line)
+		return accessViolation(filename, "is denied")
+	}
+
+	logger.Printf("acl denied %s of %q for remote: %s, no rule matched", operation, filename, addr)
+	return accessViolation(filename, "is denied")
+}
+
+func (r ACLRule) matches(filename string, isWrite bool, ip net.IP) bool {
+	if isWrite && !r.write || !isWrite && !r.read {
+		return false
+	}
+
+	if r.network != nil && (ip == nil || !r.network.Contains(ip)) {
+		return false
+	}
+
+	return MatchGlob(r.glob, filename)
+}
+
+//MatchGlob matches filename against a path.Match pattern, a pattern of ** matches
+//every filename and one ending in /** every filename under a directory.
+func MatchGlob(glob string, filename string) bool {
+	if glob == "**" {
+		return true
+	}
+
+	if strings.HasSuffix(glob, "/**") {
+		dir := strings.TrimSuffix(glob, "/**")
+		elements := strings.Count(dir, "/") + 1
+		prefix := strings.SplitAfterN(filename, "/", elements+1)
+		if len(prefix) <= elements {
+			return false
+		}
+
+		matched, _ := path.Match(dir, strings.TrimSuffix(strings.Join(prefix[:elements], ""), "/"))
+		return matched
+	}
+
+	matched, _ := path.Match(glob, filename)
+	return matched
+}
@@ -0,0 +1,96 @@
+package tftp
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+const testACL = `
+# builds upload from their subnet
+allow write 10.20.0.0/16 uploads/**
+deny read 10.30.5.0/24 secrets/*
+allow read 10.30.0.0/15 **
+allow any 127.0.0.1/32 *.txt
+`
+
+func TestACLCheck(t *testing.T) {
+	acl, err := ParseACL(strings.NewReader(testACL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	build := &net.UDPAddr{IP: net.ParseIP("10.20.1.1"), Port: 1234}
+	provisioning := &net.UDPAddr{IP: net.ParseIP("10.31.1.1"), Port: 1234}
+	restricted := &net.UDPAddr{IP: net.ParseIP("10.30.5.9"), Port: 1234}
+	local := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1234}
+
+	cases := []struct {
+		filename string
+		isWrite bool
+		addr net.Addr
+		allowed bool
+	}{
+		{"uploads/build/42/image.bin", true, build, true},
+		{"./uploads//image.bin", true, build, true},
+		{"uploads", true, build, false},
+		{"image.bin", true, build, false},
+		{"uploads/image.bin", false, build, false},
+		{"pxelinux.cfg/default", false, provisioning, true},
+		{"pxelinux.cfg/default", true, provisioning, false},
+		{"secrets/key", false, restricted, false},
+		{"secrets/sub/key", false, restricted, true},
+		{"notes.txt", true, local, true},
+		{"dir/notes.txt", true, local, false},
+	}
+
+	for _, c := range cases {
+		err := acl.Check(c.filename, c.isWrite, c.addr, DefaultLogger)
+		if c.allowed && err != nil {
+			t.Error(fmt.Sprintf("%q write %v from %s: expected to be allowed got %v", c.filename, c.isWrite, c.addr, err))
+		}
+
+		if !c.allowed {
+			tftpErr, ok := err.(TftpError)
+			if !ok || tftpErr.errorCode != AccessViolationErrorCode {
+				t.Error(fmt.Sprintf("%q write %v from %s: expected an access violation got %v", c.filename, c.isWrite, c.addr, err))
+			}
+		}
+	}
+}
+
+func TestACLNil(t *testing.T) {
+	var acl *ACL
+
+	if acl.Check("anything", true, nil, DefaultLogger) != nil {
+		t.Error("expected a nil ACL to allow every request")
+	}
+}
+
+func TestParseACLErrors(t *testing.T) {
+	for _, rule := range []string{"permit read * **", "allow delete * **", "allow read 10.0.0.0 **", "allow read * [", "allow read *"} {
+		_, err := ParseACL(strings.NewReader("# acl\n" + rule))
+		if err == nil || !strings.Contains(err.Error(), "line 2") {
+			t.Error(fmt.Sprintf("%q: expected an error on line 2 got %v", rule, err))
+		}
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	for _, c := range []struct {
+		glob string
+		filename string
+		matched bool
+	}{
+		{"**", "a/b/c", true},
+		{"*", "a/b", false},
+		{"a/*/**", "a/b/c/d", true},
+		{"a/*/**", "a/b", false},
+		{"pxelinux.cfg/01-*", "pxelinux.cfg/01-aa", true},
+	} {
+		if MatchGlob(c.glob, c.filename) != c.matched {
+			t.Error(fmt.Sprintf("%s %s: expected %v", c.glob, c.filename, c.matched))
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package tftp
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+//Backend stores the files served by the server. Reads open a file and read it
+//at offsets, writes are staged and only become visible under their filename
+//once committed.
+type Backend interface {
+	//Open opens filename for reading and returns its size.
+	Open(filename string) (ReadFile, int64, error)
+	//Create stages a new file that is stored as filename once committed. An
+	//upload the options would refuse should be refused here already.
+	Create(filename string, options UploadOptions) (StagedFile, error)
+	//Commit makes a staged file visible under its filename, applying the upload
+	//options it was created with.
+	Commit(file StagedFile) error
+	//Abort discards a staged file.
+	Abort(file StagedFile) error
+}
+
+type ReadFile interface {
+	io.ReaderAt
+	io.Closer
+}
+
+//StagedFile receives the data of a write, it is passed back to the Backend that
+//created it to be committed or aborted. Hooks can read the staged data back if
+//it also implements io.ReaderAt, and are told where the file is stored if it
+//has a Path() string method.
+type StagedFile interface {
+	io.Writer
+}
+
+//SpaceReporter is implemented by backends that know how much space is left for
+//staging files.
+type SpaceReporter interface {
+	FreeSpace() (free int64, ok bool)
+}
+
+//LocalBackend serves files from a directory on the local file system. Writes are
+//staged in a tmp directory and renamed into the root once complete, the tmp
+//directory should be on the same file system as the root for the rename to be
+//atomic.
+type LocalBackend struct {
+	root string
+	tmp string
+	symlinkPolicy SymlinkPolicy
+	logger Logger
+}
+
+func NewLocalBackend(root string, tmp string, symlinkPolicy SymlinkPolicy) *LocalBackend {
+	return &LocalBackend{root, tmp, symlinkPolicy, DefaultLogger}
+}
+
+type localStagedFile struct {
+	*os.File
+	stagedPath string
+	path string
+	filename string
+	options UploadOptions
+}
+
+//Path returns where the file is stored once committed.
+func (l *localStagedFile) Path() string {
+	return l.path
+}
+
+//StagedPath returns where the file is staged until it is committed.
+func (l *localStagedFile) StagedPath() string {
+	return l.stagedPath
+}
+
+func (l *LocalBackend) Open(filename string) (ReadFile, int64, error) {
+	path, err := ResolvePath(l.root, filename, l.symlinkPolicy)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+
+	if !fileInfo.Mode().IsRegular() {
+		file.Close()
+		return nil, 0, &os.PathError{Op: "open", Path: path, Err: syscall.EISDIR}
+	}
+
+	return file, fileInfo.Size(), nil
+}
+
+//Create stages the upload in a file of its own in the tmp directory, concurrent
+//uploads of the same filename do not share a staging file.
+func (l *LocalBackend) Create(filename string, options UploadOptions) (StagedFile, error) {
+	path, err := ResolvePath(l.root, filename, l.symlinkPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkUpload(path, filename, options)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.CreateTemp(l.tmp, "upload-*")
+	if err != nil {
+		return nil, err
+	}
+
+	// CreateTemp makes the file private, uploads are served to every client
+	err = file.Chmod(0644)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+
+	return &localStagedFile{file, file.Name(), path, filename, options}, nil
+}
+
+//checkUpload refuses an upload to path that the upload policy does not allow.
+func checkUpload(path string, filename string, options UploadOptions) error {
+	if options.Policy == UploadOverwrite {
+		return nil
+	}
+
+	_, err := os.Lstat(path)
+	if err == nil {
+		return &os.PathError{Op: "create", Path: path, Err: fs.ErrExist}
+	}
+
+	if options.Policy == UploadCreate {
+		fileInfo, err := os.Stat(filepath.Dir(path))
+		if err != nil || !fileInfo.IsDir() {
+			return accessViolation(filename, "is in a directory that does not exist")
+		}
+	}
+
+	return nil
+}
+
+func (l *LocalBackend) Commit(file StagedFile) error {
+	staged, ok := file.(*localStagedFile)
+	if !ok {
+		return errors.New("staged file was not created by this backend")
+	}
+
+	// the data is on disk before the rename makes it visible
+	err := staged.Sync()
+	if err != nil {
+		staged.Close()
+		os.Remove(staged.stagedPath)
+		return err
+	}
+
+	err = staged.Close()
+	if err != nil {
+		os.Remove(staged.stagedPath)
+		return err
+	}
+
+	err = l.commit(staged)
+	if err != nil {
+		os.Remove(staged.stagedPath)
+		return err
+	}
+
+	return nil
+}
+
+func (l *LocalBackend) commit(staged *localStagedFile) error {
+	if staged.options.Policy == UploadCreate {
+		err := checkUpload(staged.path, staged.filename, staged.options)
+		if err != nil {
+			return err
+		}
+	} else {
+		err := os.MkdirAll(filepath.Dir(staged.path), 0777)
+		if err != nil {
+			return err
+		}
+	}
+
+	// a link fails if the file exists, unlike a rename it cannot replace a file
+	// uploaded since the upload was staged.
+	if staged.options.Policy != UploadOverwrite {
+		l.logger.Printf("linking %s to %s", staged.stagedPath, staged.path)
+
+		err := os.Link(staged.stagedPath, staged.path)
+		if err != nil {
+			return err
+		}
+
+		os.Remove(staged.stagedPath)
+		return syncDir(filepath.Dir(staged.path))
+	}
+
+	if staged.options.Backup {
+		err := l.backupFile(staged.path)
+		if err != nil {
+			return err
+		}
+	}
+
+	l.logger.Printf("renaming %s to %s", staged.stagedPath, staged.path)
+
+	err := os.Rename(staged.stagedPath, staged.path)
+	if err != nil {
+		return err
+	}
+
+	return syncDir(filepath.Dir(staged.path))
+}
+
+//syncDir flushes the entries of dir to disk so a rename into it survives a crash.
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	return file.Sync()
+}
+
+//backupFile keeps the file at path under its BackupName, the file stays in place
+//until it is replaced.
+func (l *LocalBackend) backupFile(path string) error {
+	backupPath := BackupName(path, time.Now())
+
+	err := os.Link(path, backupPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	l.logger.Printf("kept %s as %s", path, backupPath)
+	return nil
+}
+
+func (l *LocalBackend) Abort(file StagedFile) error {
+	staged, ok := file.(*localStagedFile)
+	if !ok {
+		return errors.New("staged file was not created by this backend")
+	}
+
+	staged.Close()
+	return os.Remove(staged.stagedPath)
+}
+
+//FreeSpace returns the space left on the fuller of the tmp and root file systems.
+func (l *LocalBackend) FreeSpace() (int64, bool) {
+	free, ok := FreeSpace(l.tmp)
+	if !ok {
+		return 0, false
+	}
+
+	rootFree, ok := FreeSpace(l.root)
+	if !ok {
+		return 0, false
+	}
+
+	if rootFree < free {
+		free = rootFree
+	}
+
+	return free, true
+}
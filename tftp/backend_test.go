@@ -0,0 +1,252 @@
+package tftp
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalBackendOpen(t *testing.T) {
+	root := t.TempDir()
+	backend := NewLocalBackend(root, t.TempDir(), SymlinksWithinRoot)
+
+	os.WriteFile(filepath.Join(root, "boot.img"), []byte("boot image"), 0666)
+	os.Mkdir(filepath.Join(root, "images"), 0777)
+
+	file, size, err := backend.Open("boot.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer file.Close()
+
+	if size != 10 {
+		t.Error(fmt.Sprintf("expected size 10 got %d", size))
+	}
+
+	buf := make([]byte, 5)
+	_, err = file.ReadAt(buf, 5)
+	if err != nil || string(buf) != "image" {
+		t.Error(fmt.Sprintf("expected image got %q %v", buf, err))
+	}
+
+	_, _, err = backend.Open("images")
+	if tftpErr := FileError(err, "images"); tftpErr.errorCode != FileNotFoundErrorCode {
+		t.Error(fmt.Sprintf("expected a file not found error for a directory got %v", err))
+	}
+
+	_, _, err = backend.Open("../boot.img")
+	if tftpErr := FileError(err, "../boot.img"); tftpErr.errorCode != AccessViolationErrorCode {
+		t.Error(fmt.Sprintf("expected an access violation got %v", err))
+	}
+}
+
+func TestLocalBackendCommit(t *testing.T) {
+	root := t.TempDir()
+	tmp := t.TempDir()
+	backend := NewLocalBackend(root, tmp, SymlinksWithinRoot)
+
+	file, err := backend.Create("upload.bin", UploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file.Write([]byte("uploaded"))
+
+	_, err = os.Stat(filepath.Join(root, "upload.bin"))
+	if !os.IsNotExist(err) {
+		t.Error("expected the file to be hidden until it is committed")
+	}
+
+	err = backend.Commit(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(root, "upload.bin"))
+	if err != nil || string(data) != "uploaded" {
+		t.Error(fmt.Sprintf("expected uploaded got %q %v", data, err))
+	}
+
+	entries, _ := os.ReadDir(tmp)
+	if len(entries) != 0 {
+		t.Error(fmt.Sprintf("expected an empty tmp directory got %d entries", len(entries)))
+	}
+}
+
+func TestLocalBackendAbort(t *testing.T) {
+	root := t.TempDir()
+	tmp := t.TempDir()
+	backend := NewLocalBackend(root, tmp, SymlinksWithinRoot)
+
+	file, err := backend.Create("upload.bin", UploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file.Write([]byte("partial"))
+
+	err = backend.Abort(file)
+	if err != nil {
+		t.Error(err)
+	}
+
+	for _, dir := range []string{root, tmp} {
+		entries, _ := os.ReadDir(dir)
+		if len(entries) != 0 {
+			t.Error(fmt.Sprintf("expected %s to be empty got %d entries", dir, len(entries)))
+		}
+	}
+}
+
+func TestLocalBackendUploadPolicy(t *testing.T) {
+	root := t.TempDir()
+	backend := NewLocalBackend(root, t.TempDir(), SymlinksWithinRoot)
+
+	os.WriteFile(filepath.Join(root, "boot.img"), []byte("boot"), 0666)
+
+	for _, policy := range []UploadPolicy{UploadNoClobber, UploadCreate} {
+		_, err := backend.Create("boot.img", UploadOptions{Policy: policy})
+		if tftpErr := FileError(err, "boot.img"); tftpErr.errorCode != FileExistsErrorCode {
+			t.Error(fmt.Sprintf("policy %d: expected a file exists error got %v", policy, err))
+		}
+	}
+
+	_, err := backend.Create("images/boot.img", UploadOptions{Policy: UploadCreate})
+	if tftpErr := FileError(err, "images/boot.img"); tftpErr.errorCode != AccessViolationErrorCode {
+		t.Error(fmt.Sprintf("expected an access violation for a new directory got %v", err))
+	}
+
+	file, err := backend.Create("images/boot.img", UploadOptions{Policy: UploadNoClobber})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file.Write([]byte("image"))
+
+	// a file uploaded while this one was staged is not replaced
+	os.Mkdir(filepath.Join(root, "images"), 0777)
+	os.WriteFile(filepath.Join(root, "images", "boot.img"), []byte("first"), 0666)
+
+	err = backend.Commit(file)
+	if tftpErr := FileError(err, "images/boot.img"); tftpErr.errorCode != FileExistsErrorCode {
+		t.Error(fmt.Sprintf("expected a file exists error on commit got %v", err))
+	}
+
+	data, _ := os.ReadFile(filepath.Join(root, "images", "boot.img"))
+	if string(data) != "first" {
+		t.Error(fmt.Sprintf("expected first got %q", data))
+	}
+}
+
+func TestLocalBackendBackup(t *testing.T) {
+	root := t.TempDir()
+	backend := NewLocalBackend(root, t.TempDir(), SymlinksWithinRoot)
+
+	os.WriteFile(filepath.Join(root, "boot.img"), []byte("old"), 0666)
+
+	file, err := backend.Create("boot.img", UploadOptions{Policy: UploadOverwrite, Backup: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file.Write([]byte("new"))
+
+	err = backend.Commit(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := os.ReadFile(filepath.Join(root, "boot.img"))
+	if string(data) != "new" {
+		t.Error(fmt.Sprintf("expected new got %q", data))
+	}
+
+	backups, _ := filepath.Glob(filepath.Join(root, "boot.img.*Z"))
+	if len(backups) != 1 {
+		t.Fatal(fmt.Sprintf("expected 1 backup got %v", backups))
+	}
+
+	data, _ = os.ReadFile(backups[0])
+	if string(data) != "old" {
+		t.Error(fmt.Sprintf("expected the backup to hold old got %q", data))
+	}
+}
+
+func TestLocalBackendConcurrentUploads(t *testing.T) {
+	root := t.TempDir()
+	tmp := t.TempDir()
+	backend := NewLocalBackend(root, tmp, SymlinksWithinRoot)
+
+	first, err := backend.Create("config.bin", UploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := backend.Create("config.bin", UploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the uploads interleave, each must end up whole
+	for i := 0; i < 100; i++ {
+		first.Write([]byte("1"))
+		second.Write([]byte("2"))
+	}
+
+	err = backend.Commit(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := os.ReadFile(filepath.Join(root, "config.bin"))
+	if string(data) != strings.Repeat("1", 100) {
+		t.Error(fmt.Sprintf("expected the first upload got %q", data))
+	}
+
+	err = backend.Commit(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ = os.ReadFile(filepath.Join(root, "config.bin"))
+	if string(data) != strings.Repeat("2", 100) {
+		t.Error(fmt.Sprintf("expected the second upload got %q", data))
+	}
+
+	fileInfo, _ := os.Stat(filepath.Join(root, "config.bin"))
+	if fileInfo.Mode().Perm() != 0644 {
+		t.Error(fmt.Sprintf("expected an uploaded file to be readable got %s", fileInfo.Mode()))
+	}
+
+	entries, _ := os.ReadDir(tmp)
+	if len(entries) != 0 {
+		t.Error(fmt.Sprintf("expected an empty tmp directory got %d entries", len(entries)))
+	}
+}
+
+func TestLocalBackendCommitFailure(t *testing.T) {
+	root := t.TempDir()
+	tmp := t.TempDir()
+	backend := NewLocalBackend(root, tmp, SymlinksWithinRoot)
+
+	file, err := backend.Create("boot.img", UploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a directory in the way of the file makes the rename fail
+	os.MkdirAll(filepath.Join(root, "boot.img", "sub"), 0777)
+
+	err = backend.Commit(file)
+	if err == nil {
+		t.Error("expected the commit to fail")
+	}
+
+	entries, _ := os.ReadDir(tmp)
+	if len(entries) != 0 {
+		t.Error(fmt.Sprintf("expected the staged file to be removed got %d entries", len(entries)))
+	}
+}
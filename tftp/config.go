@@ -0,0 +1,389 @@
+package tftp
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	maxIOrequestBufSize = 1024
+	defaultBlockSize = 512
+	DefaultMaxBlockSize = 1468
+	DefaultMaxWindowSize = 16
+	maxReplySize = defaultBlockSize + 4
+
+	DefaultTimeout = 2 * time.Second
+	DefaultRetries = 5
+	MaxRetries = 32
+	DefaultMaxSessions = 1024
+)
+
+//Logger receives the log lines of the server, a *log.Logger is a Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+//DefaultLogger logs to stdout.
+var DefaultLogger Logger = log.New(os.Stdout, "", log.LstdFlags)
+
+//Config is read by the state machines and the server for the settings of a
+//transfer, TftpConfig is the implementation a Server is built with.
+type Config interface {
+	GetFSRoot() string
+	GetFSTmp() string
+	GetTftpIP() string
+	GetTftpPort() int
+	GetTimeout() time.Duration
+	GetRetries() int
+	GetMaxBlockSize() int
+	GetMaxUploadSize() int64
+	GetMaxWindowSize() int
+	GetBlockRollover() uint16
+	GetSymlinkPolicy() SymlinkPolicy
+	GetBackend() Backend
+	GetHandlers() *Handlers
+	GetRewriteRules() *RewriteRules
+	GetACL() *ACL
+	GetMode() ServerMode
+	GetUploadPolicy() UploadPolicy
+	GetUploadBackups() bool
+	GetQuotas() *Quotas
+	GetHooks() *Hooks
+	GetWorkers() int
+	GetScheduler() *Scheduler
+	GetPortRange() *PortRange
+	GetLogger() Logger
+}
+
+type TftpConfig struct {
+	fsroot string
+	fstmp string
+	ip string
+	port int
+	timeout time.Duration
+	retries int
+	maxBlockSize int
+	maxUploadSize int64
+	maxWindowSize int
+	blockRollover uint16
+	symlinkPolicy SymlinkPolicy
+	backend Backend
+	handlers *Handlers
+	rewriteRules *RewriteRules
+	acl *ACL
+	mode ServerMode
+	uploadPolicy UploadPolicy
+	uploadBackups bool
+	quotas *Quotas
+	hooks *Hooks
+	workers int
+	scheduler *Scheduler
+	portRange *PortRange
+	logger Logger
+}
+
+func (t TftpConfig) GetFSRoot() string {
+	return t.fsroot
+}
+
+func (t TftpConfig) GetFSTmp() string {
+	return t.fstmp
+}
+
+func (t TftpConfig) GetTftpIP() string {
+	return t.ip
+}
+
+func (t TftpConfig) GetTftpPort() int {
+	return t.port
+}
+
+//GetTimeout returns the initial retransmission timeout, the timeout doubles on
+//every retransmission of the same packet up to 255 seconds.
+func (t TftpConfig) GetTimeout() time.Duration {
+	if t.timeout <= 0 {
+		return DefaultTimeout
+	}
+
+	return t.timeout
+}
+
+//GetRetries returns the number of times a packet is retransmitted before the
+//transfer is abandoned.
+func (t TftpConfig) GetRetries() int {
+	return t.retries
+}
+
+//GetMaxBlockSize returns the largest blksize the server agrees to. The default
+//keeps a DATA packet within a 1500 byte ethernet frame.
+func (t TftpConfig) GetMaxBlockSize() int {
+	if t.maxBlockSize <= 0 {
+		return DefaultMaxBlockSize
+	}
+
+	return t.maxBlockSize
+}
+
+//GetMaxUploadSize returns the largest file a client may write, 0 if there is no
+//limit.
+func (t TftpConfig) GetMaxUploadSize() int64 {
+	return t.maxUploadSize
+}
+
+//GetMaxWindowSize returns the largest windowsize the server agrees to, a read
+//keeps this many blocks of blksize bytes in memory.
+func (t TftpConfig) GetMaxWindowSize() int {
+	if t.maxWindowSize <= 0 {
+		return DefaultMaxWindowSize
+	}
+
+	return t.maxWindowSize
+}
+
+//GetBlockRollover returns the block number that follows block 65535, 0 or 1.
+func (t TftpConfig) GetBlockRollover() uint16 {
+	return t.blockRollover
+}
+
+func (t TftpConfig) GetSymlinkPolicy() SymlinkPolicy {
+	return t.symlinkPolicy
+}
+
+//GetBackend returns the store files are read from and written to, by default
+//the fsroot directory with uploads staged in fstmp.
+func (t TftpConfig) GetBackend() Backend {
+	if t.backend == nil {
+		backend := NewLocalBackend(t.fsroot, t.fstmp, t.symlinkPolicy)
+		backend.logger = t.GetLogger()
+		return backend
+	}
+
+	return t.backend
+}
+
+//GetHandlers returns the handlers that generate or take files in place of the
+//backend, nil if there are none.
+func (t TftpConfig) GetHandlers() *Handlers {
+	return t.handlers
+}
+
+//GetRewriteRules returns the rules filenames are rewritten with before they are
+//looked up, nil if there are none.
+func (t TftpConfig) GetRewriteRules() *RewriteRules {
+	return t.rewriteRules
+}
+
+//GetACL returns the access control list requests are checked against before they
+//are processed, nil if every request is allowed.
+func (t TftpConfig) GetACL() *ACL {
+	return t.acl
+}
+
+//GetMode returns whether the server accepts reads, writes or both.
+func (t TftpConfig) GetMode() ServerMode {
+	return t.mode
+}
+
+//GetUploadPolicy returns what an upload does when the file already exists.
+func (t TftpConfig) GetUploadPolicy() UploadPolicy {
+	return t.uploadPolicy
+}
+
+//GetUploadBackups returns whether a file an upload overwrites is kept under a
+//timestamped name.
+func (t TftpConfig) GetUploadBackups() bool {
+	return t.uploadBackups
+}
+
+//GetQuotas returns the limits on the bytes clients may upload, nil if there are
+//none.
+func (t TftpConfig) GetQuotas() *Quotas {
+	return t.quotas
+}
+
+//GetHooks returns the hooks uploads are validated and observed with, nil if
+//there are none.
+func (t TftpConfig) GetHooks() *Hooks {
+	return t.hooks
+}
+
+//GetWorkers returns the number of goroutines sessions are served on, 0 if each
+//session is served on a goroutine of its own.
+func (t TftpConfig) GetWorkers() int {
+	return t.workers
+}
+
+//GetScheduler returns the limits on the sessions served at once, nil if there
+//are none.
+func (t TftpConfig) GetScheduler() *Scheduler {
+	return t.scheduler
+}
+
+//GetPortRange returns the ports session sockets are bound on, nil if the system
+//picks them.
+func (t TftpConfig) GetPortRange() *PortRange {
+	return t.portRange
+}
+
+//GetLogger returns where the server logs to, DefaultLogger if none was set.
+func (t TftpConfig) GetLogger() Logger {
+	if t.logger == nil {
+		return DefaultLogger
+	}
+
+	return t.logger
+}
+
+//Option sets a field of the TftpConfig a Server is built with.
+type Option func(config *TftpConfig)
+
+//WithAddress sets the ip and port ListenAndServe listens on.
+func WithAddress(ip string, port int) Option {
+	return func(config *TftpConfig) {
+		config.ip = ip
+		config.port = port
+	}
+}
+
+//WithFileSystem serves the files in root, uploads are staged in tmp. It is used
+//unless a backend is set with WithBackend.
+func WithFileSystem(root string, tmp string) Option {
+	return func(config *TftpConfig) {
+		config.fsroot = root
+		config.fstmp = tmp
+	}
+}
+
+func WithBackend(backend Backend) Option {
+	return func(config *TftpConfig) {
+		config.backend = backend
+	}
+}
+
+func WithHandlers(handlers *Handlers) Option {
+	return func(config *TftpConfig) {
+		config.handlers = handlers
+	}
+}
+
+func WithLogger(logger Logger) Option {
+	return func(config *TftpConfig) {
+		config.logger = logger
+	}
+}
+
+//WithTimeout sets the initial retransmission timeout, see GetTimeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(config *TftpConfig) {
+		config.timeout = timeout
+	}
+}
+
+//WithRetries sets the retransmissions of a packet before a transfer is
+//abandoned.
+func WithRetries(retries int) Option {
+	return func(config *TftpConfig) {
+		config.retries = retries
+	}
+}
+
+//WithMaxBlockSize sets the largest blksize negotiated, between MinBlockSize and
+//MaxBlockSize.
+func WithMaxBlockSize(blockSize int) Option {
+	return func(config *TftpConfig) {
+		config.maxBlockSize = blockSize
+	}
+}
+
+//WithMaxWindowSize sets the largest windowsize negotiated, between MinWindowSize
+//and MaxWindowSize.
+func WithMaxWindowSize(windowSize int) Option {
+	return func(config *TftpConfig) {
+		config.maxWindowSize = windowSize
+	}
+}
+
+//WithBlockRollover sets the block number that follows block 65535, 0 or 1.
+func WithBlockRollover(rollover uint16) Option {
+	return func(config *TftpConfig) {
+		config.blockRollover = rollover
+	}
+}
+
+func WithSymlinkPolicy(policy SymlinkPolicy) Option {
+	return func(config *TftpConfig) {
+		config.symlinkPolicy = policy
+	}
+}
+
+func WithRewriteRules(rules *RewriteRules) Option {
+	return func(config *TftpConfig) {
+		config.rewriteRules = rules
+	}
+}
+
+func WithACL(acl *ACL) Option {
+	return func(config *TftpConfig) {
+		config.acl = acl
+	}
+}
+
+func WithMode(mode ServerMode) Option {
+	return func(config *TftpConfig) {
+		config.mode = mode
+	}
+}
+
+//WithUploadPolicy sets what an upload does when the file exists and whether the
+//file it overwrites is kept.
+func WithUploadPolicy(policy UploadPolicy, backups bool) Option {
+	return func(config *TftpConfig) {
+		config.uploadPolicy = policy
+		config.uploadBackups = backups
+	}
+}
+
+//WithMaxUploadSize sets the largest file a client may write, 0 for no limit.
+func WithMaxUploadSize(size int64) Option {
+	return func(config *TftpConfig) {
+		config.maxUploadSize = size
+	}
+}
+
+func WithQuotas(quotas *Quotas) Option {
+	return func(config *TftpConfig) {
+		config.quotas = quotas
+	}
+}
+
+func WithHooks(hooks *Hooks) Option {
+	return func(config *TftpConfig) {
+		config.hooks = hooks
+	}
+}
+
+//WithWorkers serves sessions on a pool of workers goroutines, a request that
+//arrives while every worker is busy is refused. With 0, the default, each
+//session is served on a goroutine of its own.
+func WithWorkers(workers int) Option {
+	return func(config *TftpConfig) {
+		config.workers = workers
+	}
+}
+
+//WithMaxSessions limits the sessions served at once to maxSessions in total and
+//maxPerClient for each client ip, 0 for no limit. The default is
+//DefaultMaxSessions in total.
+func WithMaxSessions(maxSessions int, maxPerClient int) Option {
+	return func(config *TftpConfig) {
+		config.scheduler = NewScheduler(maxSessions, maxPerClient)
+	}
+}
+
+//WithPortRange binds session sockets on the ports of ports.
+func WithPortRange(ports *PortRange) Option {
+	return func(config *TftpConfig) {
+		config.portRange = ports
+	}
+}
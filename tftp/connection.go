@@ -0,0 +1,215 @@
+package tftp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+type Connection interface {
+	WriteTo([]byte) (numBytes int, err error)
+	ReadFrom([]byte) (numBytes int, err error)
+	SetReadTimeout(timeout time.Duration)
+	RemoteAddr() net.Addr
+	Context() context.Context
+}
+
+type UDPConnection struct {
+	addr net.Addr
+	conn net.PacketConn
+	writeTimeout uint64
+	readTimeout uint64
+	logger Logger
+	ctx context.Context
+	stop func() bool
+}
+
+//NewUDPConnection returns the connection of a session with addr served on conn.
+//Once ctx is done reads return ErrTransferAborted, a read in progress is woken.
+func NewUDPConnection(ctx context.Context, addr net.Addr, conn net.PacketConn, writeTimeout time.Duration, readTimeout time.Duration, logger Logger) *UDPConnection {
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Now())
+	})
+
+	return &UDPConnection{addr, conn, uint64(writeTimeout), uint64(readTimeout), logger, ctx, stop}
+}
+
+//Close closes the socket of the session.
+func (u *UDPConnection) Close() error {
+	u.stop()
+	return u.conn.Close()
+}
+
+func (u *UDPConnection) WriteTo(buf []byte) (numBytes int, err error) {
+	u.conn.SetWriteDeadline(time.Now().Add(time.Duration(u.writeTimeout)))
+	numBytes, err = u.conn.WriteTo(buf, u.addr)
+	return numBytes, err
+}
+
+//ReadFrom reads the next packet sent by the remote end of the session. Packets
+//from any other address or port are answered with an unknown transfer id error
+//and dropped, they do not extend the read deadline.
+func (u *UDPConnection) ReadFrom(buf []byte) (numBytes int, err error) {
+	u.conn.SetReadDeadline(time.Now().Add(time.Duration(u.readTimeout)))
+
+	// checked after the deadline is set, an abort before then would be undone
+	if u.ctx.Err() != nil {
+		return 0, ErrTransferAborted
+	}
+
+	for {
+		numBytes, addr, err := u.conn.ReadFrom(buf)
+		if err != nil {
+			if u.ctx.Err() != nil {
+				return 0, ErrTransferAborted
+			}
+
+			return numBytes, err
+		}
+
+		if sameUDPAddr(addr, u.addr) {
+			return numBytes, nil
+		}
+
+		u.logger.Printf("dropping packet from %s on session with %s", addr, u.addr)
+
+		// error packets are never answered, two strays could answer each other
+		if opcode, _ := ParseOpcode(buf[:numBytes]); opcode == errorOpcode {
+			continue
+		}
+
+		unknownTransferId := NewTftpError(UnknownTransferIdErrorCode, "unknown transfer id")
+		errorBuf := make([]byte, 4 + len(unknownTransferId.errMsg) + 1)
+		errorLength := ToTftpErrorSlice(unknownTransferId, errorBuf)
+
+		u.conn.SetWriteDeadline(time.Now().Add(time.Duration(u.writeTimeout)))
+		u.conn.WriteTo(errorBuf[:errorLength], addr)
+	}
+}
+
+//sameUDPAddr returns whether a and b are the same ip and port, the transfer id
+//of rfc1350.
+func sameUDPAddr(a net.Addr, b net.Addr) bool {
+	udpA, okA := a.(*net.UDPAddr)
+	udpB, okB := b.(*net.UDPAddr)
+	if !okA || !okB {
+		return a.String() == b.String()
+	}
+
+	return udpA.Port == udpB.Port && udpA.IP.Equal(udpB.IP)
+}
+
+func (u *UDPConnection) SetReadTimeout(timeout time.Duration) {
+	u.readTimeout = uint64(timeout)
+}
+
+func (u *UDPConnection) RemoteAddr() net.Addr {
+	return u.addr
+}
+
+//Context returns the context of the session, done once the transfer is aborted.
+func (u *UDPConnection) Context() context.Context {
+	return u.ctx
+}
+
+//IsTimeout returns whether err was caused by a read or write deadline expiring.
+func IsTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+//transmit writes packets to the connection in order and awaits the reply.
+func transmit(conn Connection, buf []byte, transfer TransferOptions, config Config, packets ...[]byte) (int, error) {
+	err := writePackets(conn, packets)
+	if err != nil {
+		return 0, err
+	}
+
+	return await(conn, buf, transfer, config, packets...)
+}
+
+//await reads the next packet from the connection into buf. If nothing arrives
+//within the timeout of the transfer packets are re-sent, with the timeout doubled
+//up to maxTimeout seconds unless the client negotiated it. Once the retries
+//configured are exhausted a TftpError is returned.
+func await(conn Connection, buf []byte, transfer TransferOptions, config Config, packets ...[]byte) (int, error) {
+	return awaitReply(conn, buf, transfer, config, nil, packets...)
+}
+
+//awaitReply is await for a reply accept returns true for, packets it returns
+//false for are ignored. An ignored packet neither re-sends packets nor restarts
+//the timeout, which runs from when packets were last sent, so a remote end
+//repeating a stale packet cannot keep the transfer waiting.
+func awaitReply(conn Connection, buf []byte, transfer TransferOptions, config Config, accept func([]byte) bool, packets ...[]byte) (int, error) {
+	timeout := transfer.timeout
+	deadline := time.Now().Add(timeout)
+	remaining := timeout
+
+	for retry := 0; ; {
+		if remaining > 0 {
+			conn.SetReadTimeout(remaining)
+			numBytes, err := conn.ReadFrom(buf)
+			if err == nil {
+				if accept == nil || accept(buf[:numBytes]) {
+					return numBytes, nil
+				}
+
+				remaining = time.Until(deadline)
+				continue
+			}
+
+			if !IsTimeout(err) {
+				return 0, err
+			}
+		}
+
+		if retry >= config.GetRetries() {
+			return 0, NewTftpError(NotDefinedErrorCode, "timed out after %d retries", retry)
+		}
+
+		retry = retry+1
+		if !transfer.negotiatedTimeout {
+			timeout = min(timeout * 2, maxTimeout * time.Second)
+		}
+
+		err := writePackets(conn, packets)
+		if err != nil {
+			return 0, err
+		}
+
+		deadline = time.Now().Add(timeout)
+		remaining = timeout
+	}
+}
+
+func writePackets(conn Connection, packets [][]byte) error {
+	for _, packet := range packets {
+		numBytes, err := conn.WriteTo(packet)
+		if err != nil {
+			return err
+		}
+
+		if numBytes != len(packet) {
+			return errors.New("unable to write complete packet")
+		}
+	}
+
+	return nil
+}
+
+//SendError sends err to the remote end of the connection as an error packet
+//with the error code ToTftpError translates it to. Nothing is sent for a
+//RemoteError since error packets are never acknowledged.
+func SendError(conn Connection, err error) {
+	tftpError, ok := ToTftpError(err)
+	if !ok {
+		return
+	}
+
+	errorBuf := make([]byte, 4 + len(tftpError.errMsg) + 1)
+	errorLength := ToTftpErrorSlice(tftpError, errorBuf)
+
+	conn.WriteTo(errorBuf[:errorLength])
+}
+
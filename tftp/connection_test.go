@@ -0,0 +1,89 @@
+package tftp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestUDPConnectionUnknownTransferId(t *testing.T) {
+	localhost := &net.UDPAddr{IP:net.ParseIP("127.0.0.1")}
+
+	server, err := net.ListenUDP("udp", localhost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := net.ListenUDP("udp", localhost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	intruder, err := net.ListenUDP("udp", localhost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer intruder.Close()
+
+	connection := NewUDPConnection(context.Background(), client.LocalAddr(), server, time.Second, time.Second, DefaultLogger)
+
+	intruder.WriteTo([]byte{0,4,0,1}, server.LocalAddr())
+	client.WriteTo([]byte{0,4,0,2}, server.LocalAddr())
+
+	buf := make([]byte, 4)
+	numBytes, err := connection.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ack, err := ParseAck(buf[:numBytes])
+	if err != nil || ack.blockNumber != 2 {
+		t.Error(fmt.Sprintf("expected the ack sent by the client got %v", buf[:numBytes]))
+	}
+
+	intruder.SetReadDeadline(time.Now().Add(time.Second))
+	errorBuf := make([]byte, 64)
+	numBytes, _, err = intruder.ReadFrom(errorBuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tftpErr, err := ParseTftpErrorSlice(errorBuf[:numBytes])
+	if err != nil || tftpErr.errorCode != UnknownTransferIdErrorCode {
+		t.Error(fmt.Sprintf("expected unknown transfer id error got %v", errorBuf[:numBytes]))
+	}
+}
+
+func TestUDPConnectionAbort(t *testing.T) {
+	localhost := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+
+	server, err := net.ListenUDP("udp", localhost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	connection := NewUDPConnection(ctx, localhost, server, time.Second, 10 * time.Second, DefaultLogger)
+	defer connection.Close()
+
+	time.AfterFunc(50 * time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err = connection.ReadFrom(make([]byte, 4))
+	if err != ErrTransferAborted {
+		t.Error(fmt.Sprintf("expected %s got %v", ErrTransferAborted, err))
+	}
+
+	if time.Since(start) > 5 * time.Second {
+		t.Error("expected the read in progress to be woken")
+	}
+
+	_, err = connection.ReadFrom(make([]byte, 4))
+	if err != ErrTransferAborted {
+		t.Error(fmt.Sprintf("expected %s once aborted got %v", ErrTransferAborted, err))
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd
+
+package tftp
+
+//FreeSpace reports that free space is unknown on platforms without statfs.
+func FreeSpace(path string) (free int64, ok bool) {
+	return 0, false
+}
@@ -0,0 +1,18 @@
+//go:build linux || darwin || freebsd
+
+package tftp
+
+import (
+	"syscall"
+)
+
+//FreeSpace returns the number of bytes available to unprivileged users on the
+//filesystem containing path, ok is false if it cannot be determined.
+func FreeSpace(path string) (free int64, ok bool) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, false
+	}
+
+	return int64(uint64(stat.Bavail) * uint64(stat.Bsize)), true
+}
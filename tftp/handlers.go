@@ -0,0 +1,156 @@
+package tftp
+
+import (
+	"errors"
+	"io"
+	"net"
+	"path"
+	"sync"
+)
+
+//Request describes the transfer a handler is serving.
+type Request struct {
+	RemoteAddr net.Addr
+	Filename string
+	Mode string
+	Options Options
+}
+
+//ReadFunc generates the content of a file for a read request. size is -1 if the
+//length of the content is not known up front, no tsize is sent in that case. A
+//reader that is also an io.Closer is closed once the transfer ends.
+type ReadFunc func(request *Request) (reader io.Reader, size int64, err error)
+
+//WriteFunc takes the content of a write request instead of it being staged.
+//The writer is closed once the final block has been received, if the transfer
+//fails it is aborted instead when it implements Aborter and closed otherwise.
+type WriteFunc func(request *Request) (io.WriteCloser, error)
+
+//Aborter is implemented by writers returned from a WriteFunc that need to know
+//a transfer failed.
+type Aborter interface {
+	Abort() error
+}
+
+type readRoute struct {
+	pattern string
+	handler ReadFunc
+}
+
+type writeRoute struct {
+	pattern string
+	handler WriteFunc
+}
+
+//Handlers routes requests to handlers by matching their filename against
+//path.Match patterns, the first pattern registered that matches wins. Requests
+//that match no pattern are served by the backend. A nil Handlers matches nothing.
+type Handlers struct {
+	mutex sync.RWMutex
+	reads []readRoute
+	writes []writeRoute
+}
+
+func NewHandlers() *Handlers {
+	return &Handlers{}
+}
+
+//HandleRead registers handler for read requests matching pattern.
+func (h *Handlers) HandleRead(pattern string, handler ReadFunc) error {
+	_, err := path.Match(pattern, "")
+	if err != nil {
+		return err
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.reads = append(h.reads, readRoute{pattern, handler})
+	return nil
+}
+
+//HandleWrite registers handler for write requests matching pattern.
+func (h *Handlers) HandleWrite(pattern string, handler WriteFunc) error {
+	_, err := path.Match(pattern, "")
+	if err != nil {
+		return err
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.writes = append(h.writes, writeRoute{pattern, handler})
+	return nil
+}
+
+//MatchRead returns the handler for a read of filename, filename is expected to
+//have been cleaned with CleanFilename.
+func (h *Handlers) MatchRead(filename string) (ReadFunc, bool) {
+	if h == nil {
+		return nil, false
+	}
+
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	for _, route := range h.reads {
+		if matched, _ := path.Match(route.pattern, filename); matched {
+			return route.handler, true
+		}
+	}
+
+	return nil, false
+}
+
+//MatchWrite returns the handler for a write of filename, filename is expected
+//to have been cleaned with CleanFilename.
+func (h *Handlers) MatchWrite(filename string) (WriteFunc, bool) {
+	if h == nil {
+		return nil, false
+	}
+
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	for _, route := range h.writes {
+		if matched, _ := path.Match(route.pattern, filename); matched {
+			return route.handler, true
+		}
+	}
+
+	return nil, false
+}
+
+//NewRequest returns the Request passed to a handler for ioRequest.
+func NewRequest(conn Connection, filename string, ioRequest IORequest) *Request {
+	return &Request{conn.RemoteAddr(), filename, ioRequest.mode, ioRequest.options}
+}
+
+//streamBackend hands the upload of a single request to a WriteFunc, committing
+//closes the writer.
+type streamBackend struct {
+	handler WriteFunc
+	request *Request
+}
+
+func (s streamBackend) Open(filename string) (ReadFile, int64, error) {
+	return nil, 0, errors.New("uploads to a handler cannot be read")
+}
+
+//Create ignores the upload options, the handler decides what to do with the
+//upload.
+func (s streamBackend) Create(filename string, options UploadOptions) (StagedFile, error) {
+	return s.handler(s.request)
+}
+
+func (s streamBackend) Commit(file StagedFile) error {
+	return file.(io.WriteCloser).Close()
+}
+
+func (s streamBackend) Abort(file StagedFile) error {
+	if aborter, ok := file.(Aborter); ok {
+		return aborter.Abort()
+	}
+
+	return file.(io.WriteCloser).Close()
+}
@@ -0,0 +1,125 @@
+package tftp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestHandlersMatch(t *testing.T) {
+	handlers := NewHandlers()
+
+	generated := func(content string) ReadFunc {
+		return func(request *Request) (io.Reader, int64, error) {
+			return strings.NewReader(content), -1, nil
+		}
+	}
+
+	handlers.HandleRead("pxelinux.cfg/01-*", generated("mac"))
+	handlers.HandleRead("pxelinux.cfg/*", generated("default"))
+
+	for filename, expected := range map[string]string{
+		"pxelinux.cfg/01-52-54-00-12-34-56": "mac",
+		"pxelinux.cfg/default": "default",
+	} {
+		handler, ok := handlers.MatchRead(filename)
+		if !ok {
+			t.Error(fmt.Sprintf("%s: expected a handler", filename))
+			continue
+		}
+
+		reader, _, _ := handler(&Request{Filename: filename})
+		content, _ := io.ReadAll(reader)
+		if string(content) != expected {
+			t.Error(fmt.Sprintf("%s: expected the %s handler got %s", filename, expected, content))
+		}
+	}
+
+	for _, filename := range []string{"pxelinux.cfg", "pxelinux.cfg/sub/default", "boot.img"} {
+		if _, ok := handlers.MatchRead(filename); ok {
+			t.Error(fmt.Sprintf("%s: expected no handler", filename))
+		}
+	}
+
+	if _, ok := handlers.MatchWrite("pxelinux.cfg/default"); ok {
+		t.Error("expected read handlers not to match writes")
+	}
+}
+
+func TestHandlersNil(t *testing.T) {
+	var handlers *Handlers
+
+	if _, ok := handlers.MatchRead("boot.img"); ok {
+		t.Error("expected a nil Handlers to match nothing")
+	}
+
+	if _, ok := handlers.MatchWrite("boot.img"); ok {
+		t.Error("expected a nil Handlers to match nothing")
+	}
+}
+
+func TestHandlersBadPattern(t *testing.T) {
+	handlers := NewHandlers()
+
+	err := handlers.HandleRead("[", func(request *Request) (io.Reader, int64, error) { return nil, 0, nil })
+	if err == nil {
+		t.Error("expected a malformed pattern to be refused")
+	}
+
+	err = handlers.HandleWrite("[", func(request *Request) (io.WriteCloser, error) { return nil, nil })
+	if err == nil {
+		t.Error("expected a malformed pattern to be refused")
+	}
+}
+
+//captureWriter records what is written to it and how the upload ended.
+type captureWriter struct {
+	bytes.Buffer
+	closed bool
+	aborted bool
+}
+
+func (c *captureWriter) Close() error {
+	c.closed = true
+	return nil
+}
+
+func (c *captureWriter) Abort() error {
+	c.aborted = true
+	return nil
+}
+
+func TestStreamBackend(t *testing.T) {
+	writer := &captureWriter{}
+	request := &Request{Filename: "upload.bin"}
+
+	backend := streamBackend{func(r *Request) (io.WriteCloser, error) {
+		if r != request {
+			t.Error("expected the handler to get the request")
+		}
+
+		return writer, nil
+	}, request}
+
+	file, err := backend.Create("upload.bin", UploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file.Write([]byte("uploaded"))
+	backend.Commit(file)
+
+	if !writer.closed || writer.aborted || writer.String() != "uploaded" {
+		t.Error(fmt.Sprintf("expected the upload to be closed got %+v", writer))
+	}
+
+	writer = &captureWriter{}
+	file, _ = backend.Create("upload.bin", UploadOptions{})
+	backend.Abort(file)
+
+	if writer.closed || !writer.aborted {
+		t.Error(fmt.Sprintf("expected the upload to be aborted got %+v", writer))
+	}
+}
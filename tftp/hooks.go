@@ -0,0 +1,231 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const hookWaitDelay = time.Second
+
+//Upload describes an upload to the hooks that validate and observe it.
+type Upload struct {
+	//Filename is the filename of the request after it was rewritten.
+	Filename string
+	//Path is where the backend stores the file, the filename for backends that
+	//are not a file system. It does not hold the upload until it is committed.
+	Path string
+	//StagedPath is the file the upload is staged in while it is validated, empty
+	//once it is committed or for backends that are not a file system.
+	StagedPath string
+	RemoteAddr net.Addr
+	Size int64
+	//Digest is the hex encoded sha256 of the content.
+	Digest string
+
+	content io.ReaderAt
+	ctx context.Context
+}
+
+//Context returns the context of the transfer, done once it is aborted. Hooks
+//that take time should give up once it is done.
+func (u *Upload) Context() context.Context {
+	if u.ctx == nil {
+		return context.Background()
+	}
+
+	return u.ctx
+}
+
+//Content returns a reader of the uploaded file, empty if the backend the upload
+//was staged in cannot read it back.
+func (u *Upload) Content() io.Reader {
+	if u.content == nil {
+		return strings.NewReader("")
+	}
+
+	return io.NewSectionReader(u.content, 0, u.Size)
+}
+
+//Validator checks an upload before it is committed, an error rejects the upload
+//and is sent to the client in place of the final ack.
+type Validator func(upload *Upload) error
+
+//Observer is told about an upload once it has been committed, an error is
+//logged.
+type Observer func(upload *Upload) error
+
+//Hooks runs validators between staging and committing an upload and observers
+//after it is committed, in the order they were added. Uploads taken by a
+//handler are not staged and do not run hooks. A nil Hooks runs nothing.
+type Hooks struct {
+	mutex sync.RWMutex
+	validators []Validator
+	observers []Observer
+}
+
+func NewHooks() *Hooks {
+	return &Hooks{}
+}
+
+//Validate adds a validator.
+func (h *Hooks) Validate(validator Validator) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.validators = append(h.validators, validator)
+}
+
+//Observe adds an observer.
+func (h *Hooks) Observe(observer Observer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.observers = append(h.observers, observer)
+}
+
+//Enabled returns whether there are any hooks to run.
+func (h *Hooks) Enabled() bool {
+	if h == nil {
+		return false
+	}
+
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	return len(h.validators) > 0 || len(h.observers) > 0
+}
+
+//RunValidators runs the validators until one rejects the upload, the rejection
+//is logged to logger. The error returned is a TftpError, errors that are not
+//are sent with code 0.
+func (h *Hooks) RunValidators(upload *Upload, logger Logger) error {
+	if h == nil {
+		return nil
+	}
+
+	h.mutex.RLock()
+	validators := h.validators
+	h.mutex.RUnlock()
+
+	for _, validator := range validators {
+		err := validator(upload)
+		if err == nil {
+			continue
+		}
+
+		logger.Printf("upload of %s from %s rejected: %s", upload.Filename, upload.RemoteAddr, err)
+
+		var tftpError TftpError
+		if errors.As(err, &tftpError) {
+			return tftpError
+		}
+
+		return NewTftpError(NotDefinedErrorCode, "upload rejected: %s", err)
+	}
+
+	return nil
+}
+
+//RunObservers runs every observer, errors are logged to logger.
+func (h *Hooks) RunObservers(upload *Upload, logger Logger) {
+	if h == nil {
+		return
+	}
+
+	h.mutex.RLock()
+	observers := h.observers
+	h.mutex.RUnlock()
+
+	for _, observer := range observers {
+		err := observer(upload)
+		if err != nil {
+			logger.Printf("observer of the upload of %s from %s failed: %s", upload.Filename, upload.RemoteAddr, err)
+		}
+	}
+}
+
+//CommandValidator returns a validator that runs command with the content of the
+//upload on stdin and the upload described by environment variables, see
+//uploadCommand. A non zero exit status rejects the upload with the first line
+//of its output.
+func CommandValidator(command string) Validator {
+	return func(upload *Upload) error {
+		output, err := uploadCommand(command, upload, true).CombinedOutput()
+		if err == nil {
+			return nil
+		}
+
+		message := strings.TrimSpace(string(output))
+		if line, _, ok := strings.Cut(message, "\n"); ok {
+			message = line
+		}
+
+		if message == "" {
+			message = err.Error()
+		}
+
+		return errors.New(message)
+	}
+}
+
+//CommandObserver returns an observer that runs command with the upload described
+//by environment variables, see uploadCommand. A non zero exit status is returned
+//as an error with the output of the command.
+func CommandObserver(command string) Observer {
+	return func(upload *Upload) error {
+		output, err := uploadCommand(command, upload, false).CombinedOutput()
+		if err != nil {
+			return errors.New(fmt.Sprintf("%s: %s %s", command, err, bytes.TrimSpace(output)))
+		}
+
+		return nil
+	}
+}
+
+//uploadCommand returns the command to run for a hook. command is run by sh, the
+//upload is passed as TFTP_FILENAME, TFTP_PATH, TFTP_SIZE, TFTP_SHA256 and
+//TFTP_CLIENT, and to validators of a staged file as TFTP_STAGED_PATH. The command
+//is killed once the transfer is aborted.
+func uploadCommand(command string, upload *Upload, content bool) *exec.Cmd {
+	cmd := exec.CommandContext(upload.Context(), "sh", "-c", command)
+
+	// children of sh holding its output are not waited for once it is killed
+	cmd.WaitDelay = hookWaitDelay
+	cmd.Env = append(os.Environ(),
+		"TFTP_FILENAME=" + upload.Filename,
+		"TFTP_PATH=" + upload.Path,
+		"TFTP_SIZE=" + strconv.FormatInt(upload.Size, 10),
+		"TFTP_SHA256=" + upload.Digest,
+		"TFTP_CLIENT=" + AddrIP(upload.RemoteAddr).String(),
+	)
+
+	if upload.StagedPath != "" {
+		cmd.Env = append(cmd.Env, "TFTP_STAGED_PATH=" + upload.StagedPath)
+	}
+
+	if content {
+		cmd.Stdin = upload.Content()
+	}
+
+	return cmd
+}
+
+//countingWriter counts the bytes written to it.
+type countingWriter struct {
+	count int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.count = c.count + int64(len(p))
+	return len(p), nil
+}
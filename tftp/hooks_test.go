@@ -0,0 +1,136 @@
+package tftp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testUpload(content string) *Upload {
+	digest := sha256.Sum256([]byte(content))
+
+	return &Upload{
+		Filename: "firmware.bin",
+		Path: "/srv/tftp/firmware.bin",
+		RemoteAddr: &net.UDPAddr{IP: net.ParseIP("10.1.2.3"), Port: 1234},
+		Size: int64(len(content)),
+		Digest: hex.EncodeToString(digest[:]),
+		content: strings.NewReader(content),
+	}
+}
+
+func TestHooksRunValidators(t *testing.T) {
+	hooks := NewHooks()
+	calls := []string{}
+
+	hooks.Validate(func(upload *Upload) error {
+		calls = append(calls, "first")
+		return nil
+	})
+
+	hooks.Validate(func(upload *Upload) error {
+		calls = append(calls, "second")
+		return errors.New("bad signature")
+	})
+
+	hooks.Validate(func(upload *Upload) error {
+		calls = append(calls, "third")
+		return nil
+	})
+
+	err := hooks.RunValidators(testUpload("firmware"), DefaultLogger)
+
+	tftpErr, ok := err.(TftpError)
+	if !ok || tftpErr.errorCode != NotDefinedErrorCode || tftpErr.errMsg != "upload rejected: bad signature" {
+		t.Error(fmt.Sprintf("expected the upload to be rejected got %v", err))
+	}
+
+	if strings.Join(calls, ",") != "first,second" {
+		t.Error(fmt.Sprintf("expected validators to stop at the rejection got %v", calls))
+	}
+
+	hooks = NewHooks()
+	hooks.Validate(func(upload *Upload) error {
+		return NewTftpError(DiskFullErrorCode, "no room for firmware")
+	})
+
+	err = hooks.RunValidators(testUpload("firmware"), DefaultLogger)
+	if tftpErr, ok := err.(TftpError); !ok || tftpErr.errorCode != DiskFullErrorCode {
+		t.Error(fmt.Sprintf("expected the TftpError of the validator got %v", err))
+	}
+}
+
+func TestHooksNil(t *testing.T) {
+	var hooks *Hooks
+
+	if hooks.Enabled() || hooks.RunValidators(testUpload(""), DefaultLogger) != nil {
+		t.Error("expected a nil Hooks to run nothing")
+	}
+
+	hooks.RunObservers(testUpload(""), DefaultLogger)
+}
+
+func TestCommandValidator(t *testing.T) {
+	validator := CommandValidator(`test "$(cat)" = signed || { echo "signature check failed"; echo more; exit 1; }`)
+
+	if err := validator(testUpload("signed")); err != nil {
+		t.Error(err)
+	}
+
+	err := validator(testUpload("tampered"))
+	if err == nil || err.Error() != "signature check failed" {
+		t.Error(fmt.Sprintf("expected the first line of the output got %v", err))
+	}
+
+	err = CommandValidator("exit 3")(testUpload("signed"))
+	if err == nil || err.Error() != "exit status 3" {
+		t.Error(fmt.Sprintf("expected the exit status got %v", err))
+	}
+}
+
+func TestCommandObserver(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "observed")
+	upload := testUpload("firmware")
+
+	observer := CommandObserver(`echo "$TFTP_FILENAME $TFTP_PATH $TFTP_SIZE $TFTP_SHA256 $TFTP_CLIENT" > ` + output)
+	err := observer(upload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := fmt.Sprintf("firmware.bin /srv/tftp/firmware.bin 8 %s 10.1.2.3\n", upload.Digest)
+	if string(data) != expected {
+		t.Error(fmt.Sprintf("expected %q got %q", expected, data))
+	}
+}
+
+func TestCommandValidatorAborted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	upload := testUpload("firmware")
+	upload.ctx = ctx
+
+	time.AfterFunc(50 * time.Millisecond, cancel)
+
+	start := time.Now()
+	err := CommandValidator("sleep 30")(upload)
+	if err == nil {
+		t.Error("expected an aborted validator to fail")
+	}
+
+	if time.Since(start) > 5 * time.Second {
+		t.Error("expected the command to be killed once the transfer is aborted")
+	}
+}
@@ -0,0 +1,205 @@
+package tftp
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+//MemoryBackend keeps files in memory, it is safe for concurrent transfers. A
+//committed upload replaces the content of a file without affecting reads that
+//already opened it.
+type MemoryBackend struct {
+	mutex sync.RWMutex
+	files map[string][]byte
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{files: map[string][]byte{}}
+}
+
+type memoryFile struct {
+	*bytes.Reader
+}
+
+func (m memoryFile) Close() error {
+	return nil
+}
+
+type memoryStagedFile struct {
+	bytes.Buffer
+	filename string
+	options UploadOptions
+	backend *MemoryBackend
+}
+
+//ReadAt reads the data staged so far.
+func (m *memoryStagedFile) ReadAt(p []byte, off int64) (int, error) {
+	return bytes.NewReader(m.Bytes()).ReadAt(p, off)
+}
+
+//Store sets the content of filename, data is copied.
+func (m *MemoryBackend) Store(filename string, data []byte) error {
+	filename, err := CleanFilename(filename)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.files[filename] = append([]byte(nil), data...)
+	return nil
+}
+
+//Load returns a copy of the content of filename, ok is false if there is no such
+//file.
+func (m *MemoryBackend) Load(filename string) (data []byte, ok bool) {
+	filename, err := CleanFilename(filename)
+	if err != nil {
+		return nil, false
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	data, ok = m.files[filename]
+	return append([]byte(nil), data...), ok
+}
+
+//Remove deletes filename.
+func (m *MemoryBackend) Remove(filename string) {
+	filename, err := CleanFilename(filename)
+	if err != nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.files, filename)
+}
+
+//LoadDir stores every regular file under root by its path relative to root.
+func (m *MemoryBackend) LoadDir(root string) error {
+	return filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		filename, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		return m.Store(filepath.ToSlash(filename), data)
+	})
+}
+
+func (m *MemoryBackend) Open(filename string) (ReadFile, int64, error) {
+	cleaned, err := CleanFilename(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	data, ok := m.files[cleaned]
+	if !ok {
+		return nil, 0, &os.PathError{Op: "open", Path: filename, Err: fs.ErrNotExist}
+	}
+
+	// stored content is never modified, only replaced, so it is read unlocked
+	return memoryFile{bytes.NewReader(data)}, int64(len(data)), nil
+}
+
+func (m *MemoryBackend) Create(filename string, options UploadOptions) (StagedFile, error) {
+	cleaned, err := CleanFilename(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	err = m.checkUpload(cleaned, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &memoryStagedFile{filename: cleaned, options: options, backend: m}, nil
+}
+
+//checkUpload refuses an upload to filename that the upload policy does not
+//allow, a directory exists while there are files under it.
+func (m *MemoryBackend) checkUpload(filename string, options UploadOptions) error {
+	if options.Policy == UploadOverwrite {
+		return nil
+	}
+
+	if _, ok := m.files[filename]; ok {
+		return &os.PathError{Op: "create", Path: filename, Err: fs.ErrExist}
+	}
+
+	dir := path.Dir(filename)
+	if options.Policy == UploadCreate && dir != "." {
+		for name := range m.files {
+			if strings.HasPrefix(name, dir + "/") {
+				return nil
+			}
+		}
+
+		return accessViolation(filename, "is in a directory that does not exist")
+	}
+
+	return nil
+}
+
+func (m *MemoryBackend) Commit(file StagedFile) error {
+	staged, ok := file.(*memoryStagedFile)
+	if !ok || staged.backend != m {
+		return errors.New("staged file was not created by this backend")
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	staged.backend = nil
+
+	err := m.checkUpload(staged.filename, staged.options)
+	if err != nil {
+		return err
+	}
+
+	if previous, ok := m.files[staged.filename]; ok && staged.options.Backup {
+		m.files[BackupName(staged.filename, time.Now())] = previous
+	}
+
+	// the buffer is dropped so the committed content cannot be written to
+	m.files[staged.filename] = staged.Bytes()
+	staged.Buffer = bytes.Buffer{}
+	return nil
+}
+
+func (m *MemoryBackend) Abort(file StagedFile) error {
+	staged, ok := file.(*memoryStagedFile)
+	if !ok || staged.backend != m {
+		return errors.New("staged file was not created by this backend")
+	}
+
+	staged.Reset()
+	staged.backend = nil
+	return nil
+}
@@ -0,0 +1,211 @@
+package tftp
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMemoryBackendOpen(t *testing.T) {
+	backend := NewMemoryBackend()
+	backend.Store("./images//boot.img", []byte("boot image"))
+
+	file, size, err := backend.Open("images/boot.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer file.Close()
+
+	if size != 10 {
+		t.Error(fmt.Sprintf("expected size 10 got %d", size))
+	}
+
+	buf := make([]byte, 5)
+	_, err = file.ReadAt(buf, 5)
+	if err != nil || string(buf) != "image" {
+		t.Error(fmt.Sprintf("expected image got %q %v", buf, err))
+	}
+
+	_, _, err = backend.Open("missing.img")
+	if tftpErr := FileError(err, "missing.img"); tftpErr.errorCode != FileNotFoundErrorCode {
+		t.Error(fmt.Sprintf("expected a file not found error got %v", err))
+	}
+
+	_, _, err = backend.Open("../images/boot.img")
+	if tftpErr := FileError(err, "../images/boot.img"); tftpErr.errorCode != AccessViolationErrorCode {
+		t.Error(fmt.Sprintf("expected an access violation got %v", err))
+	}
+}
+
+func TestMemoryBackendCommit(t *testing.T) {
+	backend := NewMemoryBackend()
+	backend.Store("upload.bin", []byte("old"))
+
+	reader, _, err := backend.Open("upload.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := backend.Create("upload.bin", UploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file.Write([]byte("uploaded"))
+
+	data, _ := backend.Load("upload.bin")
+	if string(data) != "old" {
+		t.Error("expected the file to be unchanged until it is committed")
+	}
+
+	err = backend.Commit(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ = backend.Load("upload.bin")
+	if string(data) != "uploaded" {
+		t.Error(fmt.Sprintf("expected uploaded got %q", data))
+	}
+
+	buf := make([]byte, 3)
+	reader.ReadAt(buf, 0)
+	if string(buf) != "old" {
+		t.Error(fmt.Sprintf("expected a read opened before the commit to see old got %q", buf))
+	}
+
+	if backend.Commit(file) == nil {
+		t.Error("expected a staged file to be committed only once")
+	}
+}
+
+func TestMemoryBackendAbort(t *testing.T) {
+	backend := NewMemoryBackend()
+
+	file, err := backend.Create("upload.bin", UploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file.Write([]byte("partial"))
+
+	err = backend.Abort(file)
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, ok := backend.Load("upload.bin")
+	if ok {
+		t.Error("an aborted file was stored")
+	}
+
+	if backend.Commit(file) == nil {
+		t.Error("expected an aborted file not to be committed")
+	}
+
+	if NewMemoryBackend().Abort(file) == nil {
+		t.Error("expected a staged file to be refused by another backend")
+	}
+}
+
+func TestMemoryBackendLoadDir(t *testing.T) {
+	root := t.TempDir()
+	os.Mkdir(filepath.Join(root, "pxelinux.cfg"), 0777)
+	os.WriteFile(filepath.Join(root, "pxelinux.cfg", "default"), []byte("default"), 0666)
+	os.WriteFile(filepath.Join(root, "boot.img"), []byte("boot"), 0666)
+
+	backend := NewMemoryBackend()
+	err := backend.LoadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for filename, expected := range map[string]string{"pxelinux.cfg/default": "default", "boot.img": "boot"} {
+		data, ok := backend.Load(filename)
+		if !ok || string(data) != expected {
+			t.Error(fmt.Sprintf("%s: expected %q got %q", filename, expected, data))
+		}
+	}
+}
+
+func TestMemoryBackendConcurrent(t *testing.T) {
+	backend := NewMemoryBackend()
+
+	var wait sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+
+			filename := fmt.Sprintf("file%d", i%4)
+			data := bytes.Repeat([]byte{byte(i)}, 1024)
+
+			file, _ := backend.Create(filename, UploadOptions{})
+			file.Write(data)
+			backend.Commit(file)
+
+			reader, size, err := backend.Open(filename)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			buf := make([]byte, size)
+			reader.ReadAt(buf, 0)
+			if !bytes.Equal(buf, bytes.Repeat(buf[:1], int(size))) {
+				t.Error("read a file mixing two uploads")
+			}
+		}(i)
+	}
+
+	wait.Wait()
+}
+
+func TestMemoryBackendUploadPolicy(t *testing.T) {
+	backend := NewMemoryBackend()
+	backend.Store("images/boot.img", []byte("old"))
+
+	_, err := backend.Create("images/boot.img", UploadOptions{Policy: UploadNoClobber})
+	if tftpErr := FileError(err, "images/boot.img"); tftpErr.errorCode != FileExistsErrorCode {
+		t.Error(fmt.Sprintf("expected a file exists error got %v", err))
+	}
+
+	_, err = backend.Create("kernels/vmlinuz", UploadOptions{Policy: UploadCreate})
+	if tftpErr := FileError(err, "kernels/vmlinuz"); tftpErr.errorCode != AccessViolationErrorCode {
+		t.Error(fmt.Sprintf("expected an access violation for a new directory got %v", err))
+	}
+
+	_, err = backend.Create("images/initrd.img", UploadOptions{Policy: UploadCreate})
+	if err != nil {
+		t.Error(err)
+	}
+
+	file, err := backend.Create("images/boot.img", UploadOptions{Policy: UploadOverwrite, Backup: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file.Write([]byte("new"))
+	backend.Commit(file)
+
+	data, _ := backend.Load("images/boot.img")
+	if string(data) != "new" {
+		t.Error(fmt.Sprintf("expected new got %q", data))
+	}
+
+	backups := 0
+	for name, content := range backend.files {
+		if strings.HasPrefix(name, "images/boot.img.") && string(content) == "old" {
+			backups++
+		}
+	}
+
+	if backups != 1 {
+		t.Error(fmt.Sprintf("expected 1 backup got %d", backups))
+	}
+}
@@ -0,0 +1,123 @@
+package tftp
+
+import (
+	"bufio"
+	"io"
+)
+
+/*
+netascii (rfc764, rfc1350) ends lines with CR LF and sends a bare CR as CR NUL.
+Files are stored with LF line endings, a read encodes the file and a write
+decodes the data blocks. Both conversions are streaming, an escape sequence may
+be split across two data blocks.
+*/
+
+type netasciiReader struct {
+	reader *bufio.Reader
+	pending byte
+	hasPending bool
+}
+
+//NewNetasciiReader returns a reader that encodes the text read from reader as
+//netascii.
+func NewNetasciiReader(reader io.Reader) io.Reader {
+	return &netasciiReader{reader: bufio.NewReader(reader)}
+}
+
+func (n *netasciiReader) Read(p []byte) (int, error) {
+	numBytes := 0
+
+	for numBytes < len(p) {
+		if n.hasPending {
+			p[numBytes] = n.pending
+			numBytes = numBytes+1
+			n.hasPending = false
+			continue
+		}
+
+		c, err := n.reader.ReadByte()
+		if err != nil {
+			if numBytes > 0 && err == io.EOF {
+				return numBytes, nil
+			}
+
+			return numBytes, err
+		}
+
+		switch c {
+		case '\n':
+			p[numBytes] = '\r'
+			n.pending = '\n'
+			n.hasPending = true
+		case '\r':
+			p[numBytes] = '\r'
+			n.pending = 0
+			n.hasPending = true
+		default:
+			p[numBytes] = c
+		}
+
+		numBytes = numBytes+1
+	}
+
+	return numBytes, nil
+}
+
+type netasciiWriter struct {
+	writer io.Writer
+	cr bool
+	buf []byte
+}
+
+//NewNetasciiWriter returns a writer that decodes netascii written to it and
+//writes the text to writer. Close must be called after the last write to flush
+//a trailing CR, it does not close writer.
+func NewNetasciiWriter(writer io.Writer) io.WriteCloser {
+	return &netasciiWriter{writer: writer}
+}
+
+func (n *netasciiWriter) Write(p []byte) (int, error) {
+	n.buf = n.buf[:0]
+
+	for _, c := range p {
+		if n.cr {
+			n.cr = false
+
+			switch c {
+			case '\n':
+				n.buf = append(n.buf, '\n')
+				continue
+			case 0:
+				n.buf = append(n.buf, '\r')
+				continue
+			default:
+				// a CR that is not part of an escape sequence is kept as is
+				n.buf = append(n.buf, '\r')
+			}
+		}
+
+		if c == '\r' {
+			n.cr = true
+			continue
+		}
+
+		n.buf = append(n.buf, c)
+	}
+
+	_, err := n.writer.Write(n.buf)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (n *netasciiWriter) Close() error {
+	if !n.cr {
+		return nil
+	}
+
+	n.cr = false
+	_, err := n.writer.Write([]byte{'\r'})
+	return err
+}
@@ -0,0 +1,61 @@
+package tftp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestNetasciiReader(t *testing.T) {
+
+	text := []byte("line one\nbare\rcarriage\n\n")
+	expected := []byte("line one\r\nbare\r\x00carriage\r\n\r\n")
+
+	// a one byte buffer splits every escape sequence across two reads
+	for _, size := range []int{1, 2, 3, 512} {
+		encoded := bytes.Buffer{}
+		buf := make([]byte, size)
+		reader := NewNetasciiReader(bytes.NewReader(text))
+
+		for {
+			numBytes, err := io.ReadFull(reader, buf)
+			encoded.Write(buf[:numBytes])
+			if err != nil {
+				break
+			}
+		}
+
+		if bytes.Compare(encoded.Bytes(), expected) != 0 {
+			t.Error(fmt.Sprintf("buffer size %d: expected %q got %q", size, expected, encoded.Bytes()))
+		}
+	}
+
+}
+
+func TestNetasciiWriter(t *testing.T) {
+
+	encoded := []byte("line one\r\nbare\r\x00carriage\r\n\r\nstray\rcr\r")
+	expected := []byte("line one\nbare\rcarriage\n\nstray\rcr\r")
+
+	for _, size := range []int{1, 2, 3, 512} {
+		decoded := bytes.Buffer{}
+		writer := NewNetasciiWriter(&decoded)
+
+		for i := 0; i < len(encoded); i = i+size {
+			end := i+size
+			if end > len(encoded) {
+				end = len(encoded)
+			}
+
+			writer.Write(encoded[i:end])
+		}
+
+		writer.Close()
+
+		if bytes.Compare(decoded.Bytes(), expected) != 0 {
+			t.Error(fmt.Sprintf("block size %d: expected %q got %q", size, expected, decoded.Bytes()))
+		}
+	}
+
+}
@@ -0,0 +1,323 @@
+package tftp
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	MinBlockSize = 8
+	MaxBlockSize = 65464
+
+	minTimeout = 1
+	maxTimeout = 255
+
+	MinWindowSize = 1
+	MaxWindowSize = 65535
+)
+
+//TransferOptions holds the parameters a transfer runs with once the options in
+//the request have been negotiated.
+type TransferOptions struct {
+	blockSize int
+	windowSize int
+	timeout time.Duration
+	negotiatedTimeout bool
+	fileSize int64
+	transferSize int64
+}
+
+//GetBlockSize returns the size of the data blocks of the transfer.
+func (t TransferOptions) GetBlockSize() int {
+	return t.blockSize
+}
+
+//GetWindowSize returns the number of blocks sent before waiting for an ack.
+func (t TransferOptions) GetWindowSize() int {
+	return t.windowSize
+}
+
+//GetTimeout returns the initial retransmission timeout of the transfer.
+func (t TransferOptions) GetTimeout() time.Duration {
+	return t.timeout
+}
+
+//GetFileSize returns the size of the file read as given by the tsize option, -1
+//if it is not known.
+func (t TransferOptions) GetFileSize() int64 {
+	return t.fileSize
+}
+
+//GetTransferSize returns the size of the file written as announced by the tsize
+//option, -1 if it was not.
+func (t TransferOptions) GetTransferSize() int64 {
+	return t.transferSize
+}
+
+//optionNegotiator is run by a server, it accepts the value a client requested
+//for an option and returns the value to acknowledge, ok is false when the option
+//should be left out of the OACK.
+type optionNegotiator func(value string, request IORequest, config Config, transfer *TransferOptions) (accepted string, ok bool, err error)
+
+//optionAcceptor is run by a client, it checks the value a server acknowledged
+//against the value requested and applies it to the transfer.
+type optionAcceptor func(requested string, acknowledged string, request IORequest, transfer *TransferOptions) error
+
+type optionHandler struct {
+	negotiate optionNegotiator
+	accept optionAcceptor
+}
+
+//optionHandlers holds the options understood by this implementation, options
+//not in this map are ignored per rfc2347.
+var optionHandlers = map[string]optionHandler{
+	"blksize": {negotiateBlockSize, acceptBlockSize},
+	"timeout": {negotiateTimeout, acceptTimeout},
+	"tsize": {negotiateTransferSize, acceptTransferSize},
+	"windowsize": {negotiateWindowSize, acceptWindowSize},
+}
+
+//parseBoundedInt parses an option value, ok is false if it is not a number
+//between min and max.
+func parseBoundedInt(value string, min int, max int) (int, bool) {
+	number, err := strconv.Atoi(value)
+	if err != nil || number < min || number > max {
+		return 0, false
+	}
+
+	return number, true
+}
+
+//negotiateBlockSize implements rfc2348, the block size requested is capped at the
+//configured maximum and values outside 8-65464 are ignored.
+func negotiateBlockSize(value string, request IORequest, config Config, transfer *TransferOptions) (string, bool, error) {
+	blockSize, ok := parseBoundedInt(value, MinBlockSize, MaxBlockSize)
+	if !ok {
+		return "", false, nil
+	}
+
+	if blockSize > config.GetMaxBlockSize() {
+		blockSize = config.GetMaxBlockSize()
+	}
+
+	transfer.blockSize = blockSize
+	return strconv.Itoa(blockSize), true, nil
+}
+
+//negotiateTimeout implements the timeout option of rfc2349, a timeout the client
+//asked for is used as is for every retransmission.
+func negotiateTimeout(value string, request IORequest, config Config, transfer *TransferOptions) (string, bool, error) {
+	seconds, ok := parseBoundedInt(value, minTimeout, maxTimeout)
+	if !ok {
+		return "", false, nil
+	}
+
+	transfer.timeout = time.Duration(seconds) * time.Second
+	transfer.negotiatedTimeout = true
+	return strconv.Itoa(seconds), true, nil
+}
+
+//negotiateTransferSize implements the tsize option of rfc2349. A read request is
+//answered with the size of the file. The size announced by a write request is
+//checked against the upload limit and the space left for staging the file.
+func negotiateTransferSize(value string, request IORequest, config Config, transfer *TransferOptions) (string, bool, error) {
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 0 {
+		return "", false, nil
+	}
+
+	if !request.isWrite {
+		if transfer.fileSize < 0 {
+			return "", false, nil
+		}
+
+		return strconv.FormatInt(transfer.fileSize, 10), true, nil
+	}
+
+	if config.GetMaxUploadSize() > 0 && size > config.GetMaxUploadSize() {
+		return "", false, TftpError{DiskFullErrorCode, fmt.Sprintf("file of %d bytes exceeds the upload limit of %d bytes", size, config.GetMaxUploadSize())}
+	}
+
+	reporter, ok := config.GetBackend().(SpaceReporter)
+	if ok {
+		free, ok := reporter.FreeSpace()
+		if ok && size > free {
+			return "", false, TftpError{DiskFullErrorCode, fmt.Sprintf("file of %d bytes exceeds the %d bytes free", size, free)}
+		}
+	}
+
+	transfer.transferSize = size
+	return value, true, nil
+}
+
+//negotiateWindowSize implements rfc7440, the number of blocks sent before waiting
+//for an ack is capped at the configured maximum.
+func negotiateWindowSize(value string, request IORequest, config Config, transfer *TransferOptions) (string, bool, error) {
+	windowSize, ok := parseBoundedInt(value, MinWindowSize, MaxWindowSize)
+	if !ok {
+		return "", false, nil
+	}
+
+	if windowSize > config.GetMaxWindowSize() {
+		windowSize = config.GetMaxWindowSize()
+	}
+
+	transfer.windowSize = windowSize
+	return strconv.Itoa(windowSize), true, nil
+}
+
+func acceptBlockSize(requested string, acknowledged string, request IORequest, transfer *TransferOptions) error {
+	limit, _ := strconv.Atoi(requested)
+
+	blockSize, ok := parseBoundedInt(acknowledged, MinBlockSize, limit)
+	if !ok {
+		return errors.New(fmt.Sprintf("blksize %s is not between %d and %s", acknowledged, MinBlockSize, requested))
+	}
+
+	transfer.blockSize = blockSize
+	return nil
+}
+
+func acceptTimeout(requested string, acknowledged string, request IORequest, transfer *TransferOptions) error {
+	seconds, ok := parseBoundedInt(acknowledged, minTimeout, maxTimeout)
+	if !ok || acknowledged != requested {
+		return errors.New(fmt.Sprintf("timeout %s does not match the %s requested", acknowledged, requested))
+	}
+
+	transfer.timeout = time.Duration(seconds) * time.Second
+	transfer.negotiatedTimeout = true
+	return nil
+}
+
+func acceptTransferSize(requested string, acknowledged string, request IORequest, transfer *TransferOptions) error {
+	size, err := strconv.ParseInt(acknowledged, 10, 64)
+	if err != nil || size < 0 {
+		return errors.New(fmt.Sprintf("tsize %s is not a valid size", acknowledged))
+	}
+
+	if request.isWrite {
+		if acknowledged != requested {
+			return errors.New(fmt.Sprintf("tsize %s does not match the %s requested", acknowledged, requested))
+		}
+
+		transfer.transferSize = size
+		return nil
+	}
+
+	transfer.fileSize = size
+	return nil
+}
+
+func acceptWindowSize(requested string, acknowledged string, request IORequest, transfer *TransferOptions) error {
+	limit, _ := strconv.Atoi(requested)
+
+	windowSize, ok := parseBoundedInt(acknowledged, MinWindowSize, limit)
+	if !ok {
+		return errors.New(fmt.Sprintf("windowsize %s is not between %d and %s", acknowledged, MinWindowSize, requested))
+	}
+
+	transfer.windowSize = windowSize
+	return nil
+}
+
+//defaultTransferOptions returns the parameters of a transfer that negotiated no
+//options.
+func defaultTransferOptions(config Config) TransferOptions {
+	return TransferOptions{
+		blockSize: defaultBlockSize,
+		windowSize: 1,
+		timeout: config.GetTimeout(),
+		fileSize: -1,
+		transferSize: -1,
+	}
+}
+
+//NegotiateOptions runs the options of the request through their negotiators in
+//the order the client sent them. fileSize is the size of the file being read, -1
+//when it is unknown. It returns the transfer parameters and the options to
+//acknowledge, no OACK is sent when the returned options are empty.
+func NegotiateOptions(request IORequest, fileSize int64, config Config) (TransferOptions, Options, error) {
+	transfer := defaultTransferOptions(config)
+	transfer.fileSize = fileSize
+	accepted := Options{}
+
+	for _, name := range request.options.Names() {
+		handler, ok := optionHandlers[name]
+		if !ok {
+			continue
+		}
+
+		value, _ := request.options.Get(name)
+		acceptedValue, ok, err := handler.negotiate(value, request, config, &transfer)
+		if err != nil {
+			return TransferOptions{}, Options{}, err
+		}
+
+		if ok {
+			accepted.Set(name, acceptedValue)
+		}
+	}
+
+	return transfer, accepted, nil
+}
+
+//AcceptOAck is the client side of NegotiateOptions, it checks the options a
+//server acknowledged against the options in the request and returns the
+//parameters of the transfer. A TftpError with code 8 is returned if the server
+//acknowledged an option that was not requested or with a value that cannot be
+//accepted, the client should send it to the server and abort the transfer.
+func AcceptOAck(request IORequest, oack OAck, config Config) (TransferOptions, error) {
+	transfer := defaultTransferOptions(config)
+
+	for _, name := range oack.options.Names() {
+		requested, ok := request.options.Get(name)
+		if !ok {
+			return TransferOptions{}, TftpError{OptionNegotiationErrorCode, fmt.Sprintf("option %s was not requested", name)}
+		}
+
+		handler, ok := optionHandlers[name]
+		if !ok {
+			continue
+		}
+
+		acknowledged, _ := oack.options.Get(name)
+		err := handler.accept(requested, acknowledged, request, &transfer)
+		if err != nil {
+			return TransferOptions{}, TftpError{OptionNegotiationErrorCode, err.Error()}
+		}
+	}
+
+	return transfer, nil
+}
+
+//RemoteError is returned by the state machines when the remote end aborts the
+//transfer with an error packet, no error packet is sent in reply.
+type RemoteError struct {
+	TftpError
+}
+
+func (r RemoteError) Error() string {
+	if r.errorCode == OptionNegotiationErrorCode {
+		return fmt.Sprintf("remote rejected the negotiated options: %s", r.errMsg)
+	}
+
+	return fmt.Sprintf("remote aborted the transfer with error %d: %s", r.errorCode, r.errMsg)
+}
+
+//checkRemoteError returns a RemoteError if the packet in byteSlice is an error
+//packet.
+func checkRemoteError(byteSlice []byte) error {
+	opcode, err := ParseOpcode(byteSlice)
+	if err != nil || opcode != errorOpcode {
+		return nil
+	}
+
+	tftpError, err := ParseTftpErrorSlice(byteSlice)
+	if err != nil {
+		return err
+	}
+
+	return RemoteError{tftpError}
+}
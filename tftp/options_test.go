@@ -0,0 +1,206 @@
+package tftp
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+//RegisterTestOption registers a negotiator that acknowledges the option with the
+//value requested and returns a function that removes it again.
+func RegisterTestOption(name string) func() {
+	optionHandlers[name] = optionHandler{
+		negotiate: func(value string, request IORequest, config Config, transfer *TransferOptions) (string, bool, error) {
+			return value, true, nil
+		},
+		accept: func(requested string, acknowledged string, request IORequest, transfer *TransferOptions) error {
+			return nil
+		},
+	}
+
+	return func() {
+		delete(optionHandlers, name)
+	}
+}
+
+func TestNegotiateOptionsIgnoresUnknownOptions(t *testing.T) {
+	defer RegisterTestOption("x-test")()
+
+	request := IORequest{isWrite:false, filename:"test.txt", mode:"octet"}
+	request.options.Set("x-unknown", "1")
+	request.options.Set("x-test", "2")
+
+	_, accepted, err := NegotiateOptions(request, -1, TftpConfig{})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if fmt.Sprint(accepted.Names()) != "[x-test]" {
+		t.Error(fmt.Sprintf("expected only x-test to be accepted got %v", accepted.Names()))
+	}
+}
+
+func TestCheckRemoteError(t *testing.T) {
+	errorSlice := make([]byte, 16)
+	errorLength := ToTftpErrorSlice(TftpError{8, "no"}, errorSlice)
+
+	err := checkRemoteError(errorSlice[:errorLength])
+	remoteErr, ok := err.(RemoteError)
+	if !ok || remoteErr.errorCode != 8 {
+		t.Error(fmt.Sprintf("expected a remote error with code 8 got %v", err))
+	}
+
+	if checkRemoteError([]byte{0,4,0,1}) != nil {
+		t.Error("ack was reported as a remote error")
+	}
+}
+
+func TestNegotiateBlockSize(t *testing.T) {
+	config := TftpConfig{maxBlockSize: 1428}
+
+	cases := []struct {
+		requested string
+		accepted string
+		blockSize int
+	}{
+		{"1024", "1024", 1024},
+		{"65464", "1428", 1428},
+		{"4", "", defaultBlockSize},
+		{"large", "", defaultBlockSize},
+	}
+
+	for _, c := range cases {
+		request := IORequest{isWrite:false, filename:"test.txt", mode:"octet"}
+		request.options.Set("blksize", c.requested)
+
+		transfer, accepted, err := NegotiateOptions(request, -1, config)
+		if err != nil {
+			t.Error(err)
+		}
+
+		value, _ := accepted.Get("blksize")
+		if value != c.accepted || transfer.blockSize != c.blockSize {
+			t.Error(fmt.Sprintf("blksize %s: expected %q/%d got %q/%d", c.requested, c.accepted, c.blockSize, value, transfer.blockSize))
+		}
+	}
+}
+
+func TestNegotiateTransferSizeRead(t *testing.T) {
+	request := IORequest{isWrite:false, filename:"test.txt", mode:"octet"}
+	request.options.Set("tsize", "0")
+
+	_, accepted, err := NegotiateOptions(request, 2816, TftpConfig{})
+	if err != nil {
+		t.Error(err)
+	}
+
+	value, _ := accepted.Get("tsize")
+	if value != "2816" {
+		t.Error(fmt.Sprintf("expected tsize 2816 got %q", value))
+	}
+}
+
+func TestNegotiateTransferSizeWrite(t *testing.T) {
+	config := TftpConfig{fsroot:t.TempDir(), fstmp:t.TempDir(), maxUploadSize:1024}
+
+	request := IORequest{isWrite:true, filename:"test.txt", mode:"octet"}
+	request.options.Set("tsize", "1000")
+
+	transfer, accepted, err := NegotiateOptions(request, -1, config)
+	if err != nil {
+		t.Error(err)
+	}
+
+	value, _ := accepted.Get("tsize")
+	if value != "1000" || transfer.transferSize != 1000 {
+		t.Error(fmt.Sprintf("expected tsize 1000 to be accepted got %q", value))
+	}
+
+	request.options.Set("tsize", "1025")
+
+	_, _, err = NegotiateOptions(request, -1, config)
+	if tftpErr, ok := err.(TftpError); !ok || tftpErr.errorCode != 3 {
+		t.Error(fmt.Sprintf("expected disk full error got %v", err))
+	}
+}
+
+func TestNegotiateTimeout(t *testing.T) {
+	request := IORequest{isWrite:false, filename:"test.txt", mode:"octet"}
+	request.options.Set("timeout", "3")
+
+	transfer, accepted, err := NegotiateOptions(request, -1, TftpConfig{})
+	if err != nil {
+		t.Error(err)
+	}
+
+	value, _ := accepted.Get("timeout")
+	if value != "3" || transfer.timeout != 3*time.Second || !transfer.negotiatedTimeout {
+		t.Error(fmt.Sprintf("expected timeout 3 to be accepted got %q", value))
+	}
+
+	request.options.Set("timeout", "256")
+
+	transfer, accepted, err = NegotiateOptions(request, -1, TftpConfig{timeout:time.Second})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if accepted.Len() != 0 || transfer.timeout != time.Second {
+		t.Error("expected timeout 256 to be ignored")
+	}
+}
+
+func TestNegotiateWindowSize(t *testing.T) {
+	request := IORequest{isWrite:false, filename:"test.txt", mode:"octet"}
+	request.options.Set("windowsize", "64")
+
+	transfer, accepted, err := NegotiateOptions(request, -1, TftpConfig{maxWindowSize:8})
+	if err != nil {
+		t.Error(err)
+	}
+
+	value, _ := accepted.Get("windowsize")
+	if value != "8" || transfer.windowSize != 8 {
+		t.Error(fmt.Sprintf("expected windowsize 8 got %q", value))
+	}
+}
+
+func TestAcceptOAck(t *testing.T) {
+	request := IORequest{isWrite:false, filename:"test.txt", mode:"octet"}
+	request.options.Set("blksize", "1428")
+	request.options.Set("windowsize", "16")
+	request.options.Set("tsize", "0")
+
+	oack := OAck{}
+	oack.options.Set("blksize", "1024")
+	oack.options.Set("windowsize", "8")
+	oack.options.Set("tsize", "2816")
+
+	transfer, err := AcceptOAck(request, oack, TftpConfig{})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if transfer.GetBlockSize() != 1024 || transfer.GetWindowSize() != 8 || transfer.GetFileSize() != 2816 || transfer.GetTransferSize() != -1 {
+		t.Error(fmt.Sprintf("unexpected transfer options %+v", transfer))
+	}
+
+	if transfer.GetTimeout() != (TftpConfig{}).GetTimeout() {
+		t.Error(fmt.Sprintf("expected the default timeout got %s", transfer.GetTimeout()))
+	}
+
+	oack.options.Set("blksize", "2048")
+
+	_, err = AcceptOAck(request, oack, TftpConfig{})
+	if tftpErr, ok := err.(TftpError); !ok || tftpErr.GetCode() != OptionNegotiationErrorCode {
+		t.Error(fmt.Sprintf("expected option negotiation error for a larger blksize got %v", err))
+	}
+
+	oack = OAck{}
+	oack.options.Set("timeout", "1")
+
+	_, err = AcceptOAck(request, oack, TftpConfig{})
+	if tftpErr, ok := err.(TftpError); !ok || tftpErr.errorCode != 8 {
+		t.Error(fmt.Sprintf("expected option negotiation error for an option not requested got %v", err))
+	}
+}
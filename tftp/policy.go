@@ -0,0 +1,87 @@
+package tftp
+
+import (
+	"errors"
+	"time"
+)
+
+//ServerMode decides which requests the server accepts.
+type ServerMode int
+
+const (
+	//ReadWrite accepts reads and writes.
+	ReadWrite ServerMode = iota
+	//ReadOnly refuses writes.
+	ReadOnly
+	//WriteOnly refuses reads.
+	WriteOnly
+)
+
+//ParseServerMode parses the -mode flag, one of readwrite, readonly or writeonly.
+func ParseServerMode(value string) (ServerMode, error) {
+	switch value {
+	case "readwrite":
+		return ReadWrite, nil
+	case "readonly":
+		return ReadOnly, nil
+	case "writeonly":
+		return WriteOnly, nil
+	}
+
+	return ReadWrite, errors.New("mode must be one of readwrite, readonly or writeonly")
+}
+
+//Check returns a TftpError with code 2 if the mode refuses the request.
+func (m ServerMode) Check(filename string, isWrite bool) error {
+	if isWrite && m == ReadOnly {
+		return accessViolation(filename, "cannot be written, the server is read only")
+	}
+
+	if !isWrite && m == WriteOnly {
+		return accessViolation(filename, "cannot be read, the server is write only")
+	}
+
+	return nil
+}
+
+//UploadPolicy decides what an upload does when the file already exists.
+type UploadPolicy int
+
+const (
+	//UploadOverwrite replaces an existing file and creates missing directories.
+	UploadOverwrite UploadPolicy = iota
+	//UploadNoClobber refuses to replace an existing file with error 6 and creates
+	//missing directories.
+	UploadNoClobber
+	//UploadCreate refuses to replace an existing file with error 6 and refuses
+	//files in directories that do not exist.
+	UploadCreate
+)
+
+//ParseUploadPolicy parses the -upload flag, one of overwrite, noclobber or create.
+func ParseUploadPolicy(value string) (UploadPolicy, error) {
+	switch value {
+	case "overwrite":
+		return UploadOverwrite, nil
+	case "noclobber":
+		return UploadNoClobber, nil
+	case "create":
+		return UploadCreate, nil
+	}
+
+	return UploadOverwrite, errors.New("upload policy must be one of overwrite, noclobber or create")
+}
+
+//UploadOptions are passed to a Backend when an upload is staged, the backend
+//applies them when the upload is committed.
+type UploadOptions struct {
+	Policy UploadPolicy
+	//Backup keeps the file an upload overwrites under BackupName.
+	Backup bool
+}
+
+//BackupName returns the name the previous version of filename is kept under
+//when it is overwritten at time now.
+func BackupName(filename string, now time.Time) string {
+	return filename + "." + now.UTC().Format("20060102T150405.000000000Z")
+}
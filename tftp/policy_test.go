@@ -0,0 +1,64 @@
+package tftp
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestServerModeCheck(t *testing.T) {
+	for _, c := range []struct {
+		mode ServerMode
+		isWrite bool
+		allowed bool
+	}{
+		{ReadWrite, false, true},
+		{ReadWrite, true, true},
+		{ReadOnly, false, true},
+		{ReadOnly, true, false},
+		{WriteOnly, false, false},
+		{WriteOnly, true, true},
+	} {
+		err := c.mode.Check("boot.img", c.isWrite)
+		if c.allowed != (err == nil) {
+			t.Error(fmt.Sprintf("mode %d write %v: expected allowed %v got %v", c.mode, c.isWrite, c.allowed, err))
+		}
+
+		if tftpErr, ok := err.(TftpError); err != nil && (!ok || tftpErr.errorCode != AccessViolationErrorCode) {
+			t.Error(fmt.Sprintf("expected an access violation got %v", err))
+		}
+	}
+}
+
+func TestParsePolicies(t *testing.T) {
+	for value, expected := range map[string]ServerMode{"readwrite": ReadWrite, "readonly": ReadOnly, "writeonly": WriteOnly} {
+		mode, err := ParseServerMode(value)
+		if err != nil || mode != expected {
+			t.Error(fmt.Sprintf("%s: expected %d got %d %v", value, expected, mode, err))
+		}
+	}
+
+	for value, expected := range map[string]UploadPolicy{"overwrite": UploadOverwrite, "noclobber": UploadNoClobber, "create": UploadCreate} {
+		policy, err := ParseUploadPolicy(value)
+		if err != nil || policy != expected {
+			t.Error(fmt.Sprintf("%s: expected %d got %d %v", value, expected, policy, err))
+		}
+	}
+
+	if _, err := ParseServerMode("read"); err == nil {
+		t.Error("expected an unknown mode to be refused")
+	}
+
+	if _, err := ParseUploadPolicy("replace"); err == nil {
+		t.Error("expected an unknown upload policy to be refused")
+	}
+}
+
+func TestBackupName(t *testing.T) {
+	now := time.Date(2026, 10, 17, 9, 30, 5, 123, time.FixedZone("CEST", 2*60*60))
+
+	name := BackupName("images/boot.img", now)
+	if name != "images/boot.img.20261017T073005.000000123Z" {
+		t.Error(fmt.Sprintf("unexpected backup name %s", name))
+	}
+}
@@ -0,0 +1,138 @@
+package tftp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+//PortRange allocates the ports session sockets are bound on from first to last,
+//so a firewall only needs to allow that range. Ports are handed out round robin,
+//a released port is reused only once the ports after it have been, which keeps
+//stray packets of a finished session away from the next one for as long as the
+//range allows. A port bound by another process is skipped. A nil PortRange binds
+//session sockets on a port the system picks.
+type PortRange struct {
+	mutex sync.Mutex
+	first int
+	last int
+	next int
+	used map[int]bool
+}
+
+func NewPortRange(first int, last int) (*PortRange, error) {
+	if first < 1 || last > 65535 || first > last {
+		return nil, errors.New(fmt.Sprintf("port range %d-%d is not within 1-65535", first, last))
+	}
+
+	return &PortRange{first: first, last: last, used: map[int]bool{}}, nil
+}
+
+//ParsePortRange parses a range of the form <first>-<last>.
+func ParsePortRange(value string) (*PortRange, error) {
+	first, last, ok := strings.Cut(value, "-")
+	if !ok {
+		return nil, errors.New("port range must be <first>-<last>")
+	}
+
+	firstPort, err := strconv.Atoi(first)
+	if err != nil {
+		return nil, err
+	}
+
+	lastPort, err := strconv.Atoi(last)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewPortRange(firstPort, lastPort)
+}
+
+func (p *PortRange) String() string {
+	if p == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%d-%d", p.first, p.last)
+}
+
+//Usage returns the number of ports held by sessions and the size of the range.
+func (p *PortRange) Usage() (int, int) {
+	if p == nil {
+		return 0, 0
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return len(p.used), p.last - p.first + 1
+}
+
+//Listen binds a udp socket on ip and the next free port of the range, the port
+//is released when the socket is closed. It returns an error if every port is in
+//use.
+func (p *PortRange) Listen(ip string) (net.PacketConn, error) {
+	if p == nil {
+		return net.ListenPacket("udp", net.JoinHostPort(ip, "0"))
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	size := p.last - p.first + 1
+	taken := 0
+
+	for i := 0; i < size; i++ {
+		offset := (p.next + i) % size
+		port := p.first + offset
+		if p.used[port] {
+			continue
+		}
+
+		conn, err := net.ListenPacket("udp", net.JoinHostPort(ip, strconv.Itoa(port)))
+		if errors.Is(err, syscall.EADDRINUSE) {
+			taken = taken+1
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		p.used[port] = true
+		p.next = (offset + 1) % size
+
+		return &rangeConn{PacketConn: conn, ports: p, port: port}, nil
+	}
+
+	return nil, errors.New(fmt.Sprintf("no free port in %s, %d in use by sessions and %d by other processes", p, len(p.used), taken))
+}
+
+func (p *PortRange) release(port int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	delete(p.used, port)
+}
+
+//rangeConn is a socket bound on a port of a PortRange, closing it releases the
+//port once.
+type rangeConn struct {
+	net.PacketConn
+	ports *PortRange
+	port int
+	once sync.Once
+}
+
+func (r *rangeConn) Close() error {
+	err := r.PacketConn.Close()
+	r.once.Do(func() {
+		r.ports.release(r.port)
+	})
+
+	return err
+}
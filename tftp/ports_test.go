@@ -0,0 +1,142 @@
+package tftp
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"testing"
+)
+
+func TestParsePortRange(t *testing.T) {
+	ports, err := ParsePortRange("50000-50100")
+	if err != nil || ports.String() != "50000-50100" {
+		t.Error(fmt.Sprintf("expected 50000-50100 got %v %v", ports, err))
+	}
+
+	if _, size := ports.Usage(); size != 101 {
+		t.Error(fmt.Sprintf("expected 101 ports got %d", size))
+	}
+
+	for _, value := range []string{"50000", "50100-50000", "0-10", "1-65536", "a-b", "1-"} {
+		_, err := ParsePortRange(value)
+		if err == nil {
+			t.Error(fmt.Sprintf("expected an error for %s", value))
+		}
+	}
+}
+
+//freePorts returns a range of count ports of 127.0.0.1 nothing is bound on.
+func freePorts(t *testing.T, count int) *PortRange {
+	for first := 47000; first < 48000; first = first + count {
+		free := true
+		for port := first; port < first + count && free; port++ {
+			conn, err := net.ListenPacket("udp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+			if err != nil {
+				free = false
+				continue
+			}
+
+			conn.Close()
+		}
+
+		if free {
+			ports, err := NewPortRange(first, first + count - 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			return ports
+		}
+	}
+
+	t.Skip("no free port range")
+	return nil
+}
+
+func listenPort(t *testing.T, ports *PortRange) (net.PacketConn, int) {
+	conn, err := ports.Listen("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return conn, conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestPortRangeListen(t *testing.T) {
+	ports := freePorts(t, 3)
+	first := ports.first
+
+	conn0, port0 := listenPort(t, ports)
+	conn1, port1 := listenPort(t, ports)
+	if port0 != first || port1 != first + 1 {
+		t.Error(fmt.Sprintf("expected ports %d and %d got %d and %d", first, first + 1, port0, port1))
+	}
+
+	// a released port is reused after the ports that follow it
+	conn0.Close()
+	conn0.Close()
+
+	conn2, port2 := listenPort(t, ports)
+	if port2 != first + 2 {
+		t.Error(fmt.Sprintf("expected port %d got %d", first + 2, port2))
+	}
+
+	conn3, port3 := listenPort(t, ports)
+	if port3 != first {
+		t.Error(fmt.Sprintf("expected the released port %d got %d", first, port3))
+	}
+
+	used, size := ports.Usage()
+	if used != 3 || size != 3 {
+		t.Error(fmt.Sprintf("expected 3 of 3 ports in use got %d of %d", used, size))
+	}
+
+	_, err := ports.Listen("127.0.0.1")
+	if err == nil || err.Error() != fmt.Sprintf("no free port in %s, 3 in use by sessions and 0 by other processes", ports) {
+		t.Error(fmt.Sprintf("expected the range to be exhausted got %v", err))
+	}
+
+	for _, conn := range []net.PacketConn{conn1, conn2, conn3} {
+		conn.Close()
+	}
+
+	if used, _ := ports.Usage(); used != 0 {
+		t.Error(fmt.Sprintf("expected every port to be released got %d in use", used))
+	}
+}
+
+func TestPortRangeSkipsBoundPorts(t *testing.T) {
+	ports := freePorts(t, 2)
+
+	other, err := net.ListenPacket("udp", net.JoinHostPort("127.0.0.1", strconv.Itoa(ports.first)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	conn, port := listenPort(t, ports)
+	defer conn.Close()
+
+	if port != ports.first + 1 {
+		t.Error(fmt.Sprintf("expected the port bound elsewhere to be skipped got %d", port))
+	}
+
+	_, err = ports.Listen("127.0.0.1")
+	if err == nil || err.Error() != fmt.Sprintf("no free port in %s, 1 in use by sessions and 1 by other processes", ports) {
+		t.Error(fmt.Sprintf("expected the range to be exhausted got %v", err))
+	}
+}
+
+func TestPortRangeNil(t *testing.T) {
+	var ports *PortRange
+
+	conn, err := ports.Listen("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if used, size := ports.Usage(); used != 0 || size != 0 {
+		t.Error("expected a nil range to report no usage")
+	}
+}
@@ -0,0 +1,181 @@
+package tftp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type quota struct {
+	network *net.IPNet
+	limit int64
+	used int64
+}
+
+//Quotas limits the bytes the clients on a network have stored since the server
+//started. Bytes are reserved as an upload is received, so concurrent uploads
+//share the quota, and released if the upload is not committed. An upload that
+//replaces a file only counts the bytes it adds, the bytes of a file it shrinks
+//are released. A client is counted against the first network added that
+//contains it, a client in no network is not limited. A nil Quotas limits
+//nothing.
+type Quotas struct {
+	mutex sync.Mutex
+	quotas []*quota
+}
+
+func NewQuotas() *Quotas {
+	return &Quotas{}
+}
+
+//Add limits the clients in the network cidr to limit bytes.
+func (q *Quotas) Add(cidr string, limit int64) error {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+
+	if limit < 0 {
+		return errors.New(fmt.Sprintf("quota of %d bytes for %s is negative", limit, cidr))
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.quotas = append(q.quotas, &quota{network, limit, 0})
+	return nil
+}
+
+//Set parses a quota of the form <cidr>=<bytes> and adds it, it implements
+//flag.Value so the -quota flag can be repeated.
+func (q *Quotas) Set(value string) error {
+	cidr, limit, ok := strings.Cut(value, "=")
+	if !ok {
+		return errors.New("quota must be <cidr>=<bytes>")
+	}
+
+	bytes, err := strconv.ParseInt(limit, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	return q.Add(cidr, bytes)
+}
+
+func (q *Quotas) String() string {
+	if q == nil {
+		return ""
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	quotas := []string{}
+	for _, quota := range q.quotas {
+		quotas = append(quotas, fmt.Sprintf("%s=%d", quota.network, quota.limit))
+	}
+
+	return strings.Join(quotas, ",")
+}
+
+func (q *Quotas) find(addr net.Addr) *quota {
+	ip := AddrIP(addr)
+	if ip == nil {
+		return nil
+	}
+
+	for _, quota := range q.quotas {
+		if quota.network.Contains(ip) {
+			return quota
+		}
+	}
+
+	return nil
+}
+
+//Reserve counts size bytes uploaded by the client at addr against its quota. A
+//TftpError with code 3 is returned, and nothing is counted, if the quota would
+//be exceeded.
+func (q *Quotas) Reserve(addr net.Addr, size int64) error {
+	if q == nil {
+		return nil
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	quota := q.find(addr)
+	if quota == nil {
+		return nil
+	}
+
+	if quota.used + size > quota.limit {
+		return NewTftpError(DiskFullErrorCode, "upload exceeds the quota of %d bytes for %s", quota.limit, quota.network)
+	}
+
+	quota.used = quota.used + size
+	return nil
+}
+
+//Check returns a TftpError with code 3 if size more bytes from the client at addr
+//would exceed its quota, nothing is reserved.
+func (q *Quotas) Check(addr net.Addr, size int64) error {
+	if q == nil {
+		return nil
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	quota := q.find(addr)
+	if quota == nil {
+		return nil
+	}
+
+	if quota.used + size > quota.limit {
+		return NewTftpError(DiskFullErrorCode, "file of %d bytes exceeds the %d bytes left of the quota for %s", size, quota.limit - quota.used, quota.network)
+	}
+
+	return nil
+}
+
+//Release returns size bytes reserved by the client at addr to its quota.
+func (q *Quotas) Release(addr net.Addr, size int64) {
+	if q == nil {
+		return
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	quota := q.find(addr)
+	if quota == nil {
+		return
+	}
+
+	quota.used = quota.used - size
+	if quota.used < 0 {
+		quota.used = 0
+	}
+}
+
+//Used returns the bytes counted against the quota of the client at addr, ok is
+//false if the client has no quota.
+func (q *Quotas) Used(addr net.Addr) (used int64, ok bool) {
+	if q == nil {
+		return 0, false
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	quota := q.find(addr)
+	if quota == nil {
+		return 0, false
+	}
+
+	return quota.used, true
+}
@@ -0,0 +1,96 @@
+package tftp
+
+import (
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestQuotas(t *testing.T) {
+	quotas := NewQuotas()
+	quotas.Set("10.20.0.0/16=1000")
+	quotas.Add("10.0.0.0/8", 100)
+
+	build := &net.UDPAddr{IP: net.ParseIP("10.20.1.1"), Port: 1234}
+	otherBuild := &net.UDPAddr{IP: net.ParseIP("10.20.9.9"), Port: 4321}
+	lab := &net.UDPAddr{IP: net.ParseIP("10.1.1.1"), Port: 1234}
+	outside := &net.UDPAddr{IP: net.ParseIP("192.168.1.1"), Port: 1234}
+
+	if err := quotas.Reserve(build, 600); err != nil {
+		t.Error(err)
+	}
+
+	// the quota is shared by the network
+	err := quotas.Reserve(otherBuild, 600)
+	if tftpErr, ok := err.(TftpError); !ok || tftpErr.errorCode != DiskFullErrorCode {
+		t.Error(fmt.Sprintf("expected a disk full error got %v", err))
+	}
+
+	if err := quotas.Reserve(otherBuild, 400); err != nil {
+		t.Error(err)
+	}
+
+	if used, ok := quotas.Used(build); !ok || used != 1000 {
+		t.Error(fmt.Sprintf("expected 1000 bytes used got %d", used))
+	}
+
+	quotas.Release(build, 400)
+	if used, _ := quotas.Used(otherBuild); used != 600 {
+		t.Error(fmt.Sprintf("expected 600 bytes used got %d", used))
+	}
+
+	if err := quotas.Reserve(lab, 101); err == nil {
+		t.Error("expected the first matching network to apply")
+	}
+
+	if err := quotas.Reserve(outside, 1 << 40); err != nil {
+		t.Error("expected a client in no network not to be limited")
+	}
+
+	if _, ok := quotas.Used(outside); ok {
+		t.Error("expected a client in no network to have no quota")
+	}
+
+	if quotas.String() != "10.20.0.0/16=1000,10.0.0.0/8=100" {
+		t.Error(fmt.Sprintf("unexpected quotas %s", quotas))
+	}
+}
+
+func TestQuotasCheck(t *testing.T) {
+	quotas := NewQuotas()
+	quotas.Add("10.0.0.0/8", 1000)
+
+	client := &net.UDPAddr{IP: net.ParseIP("10.1.1.1"), Port: 1234}
+	quotas.Reserve(client, 400)
+
+	if err := quotas.Check(client, 600); err != nil {
+		t.Error(err)
+	}
+
+	err := quotas.Check(client, 601)
+	if err == nil || err.Error() != "file of 601 bytes exceeds the 600 bytes left of the quota for 10.0.0.0/8" {
+		t.Error(fmt.Sprintf("expected the quota to be exceeded got %v", err))
+	}
+
+	if used, _ := quotas.Used(client); used != 400 {
+		t.Error(fmt.Sprintf("expected Check to reserve nothing got %d bytes used", used))
+	}
+}
+
+func TestQuotasNil(t *testing.T) {
+	var quotas *Quotas
+
+	if quotas.Reserve(nil, 1 << 40) != nil {
+		t.Error("expected a nil Quotas to limit nothing")
+	}
+
+	quotas.Release(nil, 1)
+}
+
+func TestQuotasSetErrors(t *testing.T) {
+	for _, value := range []string{"10.0.0.0/8", "10.0.0.0=100", "10.0.0.0/8=lots", "10.0.0.0/8=-1"} {
+		if NewQuotas().Set(value) == nil {
+			t.Error(fmt.Sprintf("%s: expected an error", value))
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package tftp
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+//SymlinkPolicy decides whether symlinks under the file system root are followed.
+type SymlinkPolicy int
+
+const (
+	//SymlinksWithinRoot follows symlinks that resolve to a path under the root.
+	SymlinksWithinRoot SymlinkPolicy = iota
+	//SymlinksDeny refuses any path that contains a symlink.
+	SymlinksDeny
+	//SymlinksFollow follows every symlink, even out of the root.
+	SymlinksFollow
+)
+
+//ParseSymlinkPolicy parses the -symlinks flag, one of root, deny or follow.
+func ParseSymlinkPolicy(value string) (SymlinkPolicy, error) {
+	switch value {
+	case "root":
+		return SymlinksWithinRoot, nil
+	case "deny":
+		return SymlinksDeny, nil
+	case "follow":
+		return SymlinksFollow, nil
+	}
+
+	return SymlinksWithinRoot, errors.New("symlink policy must be one of root, deny or follow")
+}
+
+func accessViolation(filename string, reason string) TftpError {
+	return NewTftpError(AccessViolationErrorCode, "access violation: %s %s", filename, reason)
+}
+
+//CleanFilename returns the slash separated path a filename refers to relative to
+//the root, filenames that are not allowed are refused with an access violation.
+func CleanFilename(filename string) (string, error) {
+	for _, c := range filename {
+		if c < 0x20 || c == 0x7f {
+			return "", accessViolation("filename", "contains control characters")
+		}
+	}
+
+	if strings.HasPrefix(filename, "/") || filepath.IsAbs(filename) || filepath.VolumeName(filename) != "" {
+		return "", accessViolation(filename, "is an absolute path")
+	}
+
+	cleaned := path.Clean(filename)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", accessViolation(filename, "is outside the root")
+	}
+
+	return cleaned, nil
+}
+
+//ResolvePath maps the filename of a request to a path under root. Filenames with
+//control characters, absolute filenames and filenames that escape root with ..
+//are refused with an access violation, as are symlinks the policy does not
+//allow. The file itself does not need to exist.
+func ResolvePath(root string, filename string, policy SymlinkPolicy) (string, error) {
+	cleaned, err := CleanFilename(filename)
+	if err != nil {
+		return "", err
+	}
+
+	resolved := filepath.Join(root, filepath.FromSlash(cleaned))
+	if policy == SymlinksFollow {
+		return resolved, nil
+	}
+
+	// symlink targets are absolute or relative to the symlink, they are
+	// compared with the root as an absolute path.
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+
+	realRoot, err := filepath.EvalSymlinks(absRoot)
+	if err != nil {
+		return "", err
+	}
+
+	// walk the path one element at a time, elements that do not exist yet are
+	// created by a write and cannot be symlinks.
+	current := absRoot
+	for _, element := range strings.Split(cleaned, "/") {
+		current = filepath.Join(current, element)
+
+		fileInfo, err := os.Lstat(current)
+		if err != nil {
+			if os.IsNotExist(err) {
+				break
+			}
+
+			return "", err
+		}
+
+		if fileInfo.Mode() & os.ModeSymlink == 0 {
+			continue
+		}
+
+		if policy == SymlinksDeny {
+			return "", accessViolation(filename, "contains a symlink")
+		}
+
+		target, err := filepath.EvalSymlinks(current)
+		if err != nil {
+			return "", accessViolation(filename, "contains a broken symlink")
+		}
+
+		if !IsWithin(realRoot, target) {
+			return "", accessViolation(filename, "links outside the root")
+		}
+	}
+
+	return resolved, nil
+}
+
+//IsWithin returns whether target is root or a path under root.
+func IsWithin(root string, target string) bool {
+	rel, err := filepath.Rel(root, target)
+	if err != nil {
+		return false
+	}
+
+	return rel != ".." && !strings.HasPrefix(rel, ".." + string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
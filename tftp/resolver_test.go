@@ -0,0 +1,99 @@
+package tftp
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolvePathRefusesEscapes(t *testing.T) {
+	root := t.TempDir()
+
+	for _, filename := range []string{"../etc/shadow", "a/../../etc/shadow", "/etc/shadow", "..", ".", "a\x00b", "a\nb", "a\x7fb"} {
+		_, err := ResolvePath(root, filename, SymlinksWithinRoot)
+
+		tftpErr, ok := err.(TftpError)
+		if !ok || tftpErr.errorCode != AccessViolationErrorCode {
+			t.Error(fmt.Sprintf("%q: expected an access violation got %v", filename, err))
+		}
+	}
+}
+
+func TestResolvePath(t *testing.T) {
+	root := t.TempDir()
+
+	for filename, expected := range map[string]string{
+		"test.txt": "test.txt",
+		"pxelinux.cfg/default": "pxelinux.cfg/default",
+		"a/../b.txt": "b.txt",
+		"./a//b.txt": "a/b.txt",
+	} {
+		resolved, err := ResolvePath(root, filename, SymlinksDeny)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if resolved != filepath.Join(root, expected) {
+			t.Error(fmt.Sprintf("%q: expected %s got %s", filename, filepath.Join(root, expected), resolved))
+		}
+	}
+}
+
+func TestResolvePathSymlinks(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+
+	os.Mkdir(filepath.Join(root, "images"), 0777)
+	os.WriteFile(filepath.Join(root, "images", "boot.img"), []byte("boot"), 0666)
+	os.Symlink(filepath.Join(root, "images"), filepath.Join(root, "current"))
+	os.Symlink(outside, filepath.Join(root, "escape"))
+
+	cases := []struct {
+		filename string
+		policy SymlinkPolicy
+		allowed bool
+	}{
+		{"current/boot.img", SymlinksWithinRoot, true},
+		{"escape/passwd", SymlinksWithinRoot, false},
+		{"current/boot.img", SymlinksDeny, false},
+		{"images/boot.img", SymlinksDeny, true},
+		{"escape/passwd", SymlinksFollow, true},
+	}
+
+	for _, c := range cases {
+		_, err := ResolvePath(root, c.filename, c.policy)
+		if c.allowed && err != nil {
+			t.Error(fmt.Sprintf("%s with policy %d: %v", c.filename, c.policy, err))
+		}
+
+		if !c.allowed && err == nil {
+			t.Error(fmt.Sprintf("%s with policy %d: expected an access violation", c.filename, c.policy))
+		}
+	}
+}
+
+func TestResolvePathRelativeRoot(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+
+	os.MkdirAll(filepath.Join("root", "images"), 0777)
+	os.Symlink(filepath.Join(dir, "root", "images"), filepath.Join("root", "current"))
+	os.Symlink("images", filepath.Join("root", "relative"))
+	os.Symlink(t.TempDir(), filepath.Join("root", "escape"))
+
+	for filename, allowed := range map[string]bool{
+		"current/boot.img": true,
+		"relative/boot.img": true,
+		"escape/passwd": false,
+	} {
+		resolved, err := ResolvePath("root", filename, SymlinksWithinRoot)
+		if allowed && (err != nil || resolved != filepath.Join("root", filename)) {
+			t.Error(fmt.Sprintf("%q: expected %s got %s %v", filename, filepath.Join("root", filename), resolved, err))
+		}
+
+		if !allowed && err == nil {
+			t.Error(fmt.Sprintf("%q: expected an access violation", filename))
+		}
+	}
+}
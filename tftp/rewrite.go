@@ -0,0 +1,261 @@
+package tftp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+)
+
+/*
+Rewrite rules file, one rule per line, blank lines and lines starting with # are
+ignored:
+
+	<ops> <regex> [<replacement>] [@<cidr>]
+
+ops is a set of letters:
+
+	r  replace the first match of regex with replacement
+	g  replace every match of regex with replacement
+	l  lower case the filename if regex matches
+	u  upper case the filename if regex matches
+	a  refuse the request with an access violation if regex matches
+	i  match regex ignoring case
+	e  stop rewriting if regex matches
+	G  only apply the rule to reads
+	P  only apply the rule to writes
+
+A replacement is required by r and g, "" replaces with nothing. \0 to \9 in a
+replacement are the match and its groups, \i is the ip of the client. A rule
+ending in @<cidr> only applies to clients within the network. Rules are applied
+in order to the filename left by the rules before them.
+*/
+
+type RewriteRule struct {
+	line int
+	ops string
+	regex *regexp.Regexp
+	replacement string
+	network *net.IPNet
+}
+
+//RewriteRules rewrites the filename of requests before they are looked up. A nil
+//RewriteRules leaves filenames unchanged.
+type RewriteRules struct {
+	rules []RewriteRule
+}
+
+//LoadRewriteRules parses the rules file at filename.
+func LoadRewriteRules(filename string) (*RewriteRules, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	return ParseRewriteRules(file)
+}
+
+//ParseRewriteRules parses the rules read from reader.
+func ParseRewriteRules(reader io.Reader) (*RewriteRules, error) {
+	rules := &RewriteRules{}
+	scanner := bufio.NewScanner(reader)
+
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		rule, err := parseRewriteRule(line, fields)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("rewrite rule on line %d: %s", line, err))
+		}
+
+		rules.rules = append(rules.rules, rule)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+func parseRewriteRule(line int, fields []string) (RewriteRule, error) {
+	rule := RewriteRule{line: line, ops: fields[0]}
+
+	if strings.Trim(rule.ops, "rgluaieGP") != "" {
+		return rule, errors.New(fmt.Sprintf("unknown ops %s", rule.ops))
+	}
+
+	if len(fields) < 2 {
+		return rule, errors.New("missing regex")
+	}
+
+	expression := fields[1]
+	if strings.Contains(rule.ops, "i") {
+		expression = "(?i)" + expression
+	}
+
+	regex, err := regexp.Compile(expression)
+	if err != nil {
+		return rule, err
+	}
+
+	rule.regex = regex
+	rest := fields[2:]
+
+	if strings.ContainsAny(rule.ops, "rg") {
+		if len(rest) == 0 {
+			return rule, errors.New("missing replacement")
+		}
+
+		rule.replacement = rest[0]
+		if rule.replacement == `""` {
+			rule.replacement = ""
+		}
+
+		rest = rest[1:]
+	}
+
+	if len(rest) > 0 && strings.HasPrefix(rest[0], "@") {
+		_, network, err := net.ParseCIDR(rest[0][1:])
+		if err != nil {
+			return rule, err
+		}
+
+		rule.network = network
+		rest = rest[1:]
+	}
+
+	if len(rest) > 0 {
+		return rule, errors.New(fmt.Sprintf("unexpected %s", rest[0]))
+	}
+
+	return rule, nil
+}
+
+//Rewrite applies the rules to the filename of a request from addr, every
+//rewrite is logged to logger. A TftpError with code 2 is returned if a rule
+//refuses it.
+func (r *RewriteRules) Rewrite(filename string, isWrite bool, addr net.Addr, logger Logger) (string, error) {
+	if r == nil {
+		return filename, nil
+	}
+
+	ip := AddrIP(addr)
+
+	for _, rule := range r.rules {
+		if !rule.appliesTo(isWrite, ip) || !rule.regex.MatchString(filename) {
+			continue
+		}
+
+		if strings.Contains(rule.ops, "a") {
+			logger.Printf("rewrite rule on line %d refused %q for remote: %s", rule.line, filename, addr)
+			return filename, accessViolation(filename, "is refused")
+		}
+
+		rewritten := filename
+		template := rule.template(ip)
+
+		switch {
+		case strings.Contains(rule.ops, "g"):
+			rewritten = rule.regex.ReplaceAllString(rewritten, template)
+		case strings.Contains(rule.ops, "r"):
+			match := rule.regex.FindStringSubmatchIndex(rewritten)
+			replaced := rule.regex.ExpandString(nil, template, rewritten, match)
+			rewritten = rewritten[:match[0]] + string(replaced) + rewritten[match[1]:]
+		}
+
+		if strings.Contains(rule.ops, "l") {
+			rewritten = strings.ToLower(rewritten)
+		}
+
+		if strings.Contains(rule.ops, "u") {
+			rewritten = strings.ToUpper(rewritten)
+		}
+
+		if rewritten != filename {
+			logger.Printf("rewrite rule on line %d rewrote %q to %q for remote: %s", rule.line, filename, rewritten, addr)
+			filename = rewritten
+		}
+
+		if strings.Contains(rule.ops, "e") {
+			break
+		}
+	}
+
+	return filename, nil
+}
+
+func (r RewriteRule) appliesTo(isWrite bool, ip net.IP) bool {
+	if isWrite && strings.Contains(r.ops, "G") {
+		return false
+	}
+
+	if !isWrite && strings.Contains(r.ops, "P") {
+		return false
+	}
+
+	return r.network == nil || (ip != nil && r.network.Contains(ip))
+}
+
+//template converts the replacement to the syntax of regexp.Expand for a client
+//at ip.
+func (r RewriteRule) template(ip net.IP) string {
+	var template strings.Builder
+
+	for i := 0; i < len(r.replacement); i++ {
+		c := r.replacement[i]
+
+		switch {
+		case c == '$':
+			template.WriteString("$$")
+		case c == '\\' && i+1 < len(r.replacement):
+			i++
+			next := r.replacement[i]
+
+			switch {
+			case next >= '0' && next <= '9':
+				template.WriteString("${" + string(next) + "}")
+			case next == 'i':
+				if ip != nil {
+					template.WriteString(ip.String())
+				}
+			default:
+				template.WriteByte(next)
+			}
+		default:
+			template.WriteByte(c)
+		}
+	}
+
+	return template.String()
+}
+
+//AddrIP returns the ip of addr, nil if it has none.
+func AddrIP(addr net.Addr) net.IP {
+	switch addr := addr.(type) {
+	case *net.UDPAddr:
+		return addr.IP
+	case *net.TCPAddr:
+		return addr.IP
+	case *net.IPAddr:
+		return addr.IP
+	case nil:
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+
+	return net.ParseIP(host)
+}
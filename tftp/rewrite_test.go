@@ -0,0 +1,81 @@
+package tftp
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+const testRules = `
+# windows pxe roms send backslashes
+g \\ /
+r ^/+ ""
+# boot files are stored in lower case
+l ^boot/
+ri ^PXELINUX\.CFG/ pxelinux.cfg/
+r ^pxelinux.cfg/default$ pxelinux.cfg/\i @10.1.0.0/16
+a \.\./secret
+rP ^(.*)\.log$ logs/\1-\i.log
+rGe ^tmp/(.*)$ \1
+rG ^(.*)$ unreachable/\1 @192.168.0.0/16
+`
+
+func TestRewriteRules(t *testing.T) {
+	rules, err := ParseRewriteRules(strings.NewReader(testRules))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &net.UDPAddr{IP: net.ParseIP("10.1.2.3"), Port: 1234}
+	other := &net.UDPAddr{IP: net.ParseIP("192.168.1.1"), Port: 1234}
+
+	cases := []struct {
+		filename string
+		isWrite bool
+		addr net.Addr
+		expected string
+	}{
+		{`\boot\PXELINUX.0`, false, client, "boot/pxelinux.0"},
+		{"//images/Boot.IMG", false, client, "images/Boot.IMG"},
+		{"PxeLinux.Cfg/default", false, client, "pxelinux.cfg/10.1.2.3"},
+		{"pxelinux.cfg/default", false, other, "unreachable/pxelinux.cfg/default"},
+		{"console.log", true, client, "logs/console-10.1.2.3.log"},
+		{"console.log", false, client, "console.log"},
+		{"tmp/cost$1", false, other, "cost$1"},
+	}
+
+	for _, c := range cases {
+		rewritten, err := rules.Rewrite(c.filename, c.isWrite, c.addr, DefaultLogger)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if rewritten != c.expected {
+			t.Error(fmt.Sprintf("%q: expected %q got %q", c.filename, c.expected, rewritten))
+		}
+	}
+
+	_, err = rules.Rewrite("a/../secret", false, client, DefaultLogger)
+	if tftpErr, ok := err.(TftpError); !ok || tftpErr.errorCode != AccessViolationErrorCode {
+		t.Error(fmt.Sprintf("expected an access violation got %v", err))
+	}
+}
+
+func TestRewriteRulesNil(t *testing.T) {
+	var rules *RewriteRules
+
+	rewritten, err := rules.Rewrite(`\boot`, false, nil, DefaultLogger)
+	if err != nil || rewritten != `\boot` {
+		t.Error(fmt.Sprintf("expected nil rules to leave the filename got %q %v", rewritten, err))
+	}
+}
+
+func TestParseRewriteRulesErrors(t *testing.T) {
+	for _, rule := range []string{"x ^a b", "r", "r ^a", "r ( b", "r ^a b @10.0.0.0", "l ^a extra"} {
+		_, err := ParseRewriteRules(strings.NewReader("# rules\n" + rule))
+		if err == nil || !strings.Contains(err.Error(), "line 2") {
+			t.Error(fmt.Sprintf("%q: expected an error on line 2 got %v", rule, err))
+		}
+	}
+}
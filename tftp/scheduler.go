@@ -0,0 +1,92 @@
+package tftp
+
+import (
+	"net"
+	"sync"
+)
+
+//Scheduler limits the sessions served at once, in total and per client ip, so
+//a client with many transfers cannot take every session from the others. A
+//request over a limit is refused at once rather than queued, the client retries
+//it. A limit of 0 is no limit, a nil Scheduler limits nothing.
+type Scheduler struct {
+	mutex sync.Mutex
+	maxSessions int
+	maxPerClient int
+	active int
+	clients map[string]int
+}
+
+func NewScheduler(maxSessions int, maxPerClient int) *Scheduler {
+	return &Scheduler{maxSessions: maxSessions, maxPerClient: maxPerClient, clients: map[string]int{}}
+}
+
+//Acquire counts a session of the client at addr against the limits, it returns
+//a TftpError if one would be exceeded.
+func (s *Scheduler) Acquire(addr net.Addr) error {
+	if s == nil {
+		return nil
+	}
+
+	client := clientKey(addr)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.maxSessions > 0 && s.active >= s.maxSessions {
+		return NewTftpError(NotDefinedErrorCode, "server busy, %d transfers in progress, try again later", s.active)
+	}
+
+	if s.maxPerClient > 0 && s.clients[client] >= s.maxPerClient {
+		return NewTftpError(NotDefinedErrorCode, "too many transfers from %s, at most %d at once", client, s.maxPerClient)
+	}
+
+	s.active = s.active+1
+	s.clients[client] = s.clients[client]+1
+	return nil
+}
+
+//Release ends a session Acquire counted for the client at addr.
+func (s *Scheduler) Release(addr net.Addr) {
+	if s == nil {
+		return
+	}
+
+	client := clientKey(addr)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.active = s.active-1
+	s.clients[client] = s.clients[client]-1
+	if s.clients[client] <= 0 {
+		delete(s.clients, client)
+	}
+}
+
+//Active returns the number of sessions in progress, in total and of the client
+//at addr.
+func (s *Scheduler) Active(addr net.Addr) (int, int) {
+	if s == nil {
+		return 0, 0
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.active, s.clients[clientKey(addr)]
+}
+
+//clientKey returns the ip of addr, the sessions of a client share its ip but not
+//its port.
+func clientKey(addr net.Addr) string {
+	if ip := AddrIP(addr); ip != nil {
+		return ip.String()
+	}
+
+	if addr == nil {
+		return ""
+	}
+
+	return addr.String()
+}
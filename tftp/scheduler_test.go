@@ -0,0 +1,72 @@
+package tftp
+
+import (
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestScheduler(t *testing.T) {
+	scheduler := NewScheduler(3, 2)
+
+	a1 := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1000}
+	a2 := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1001}
+	a3 := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1002}
+	b := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 1000}
+	c := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 3), Port: 1000}
+
+	if scheduler.Acquire(a1) != nil || scheduler.Acquire(a2) != nil {
+		t.Fatal("expected two sessions of a client to be allowed")
+	}
+
+	err := scheduler.Acquire(a3)
+	if err == nil || err.Error() != "too many transfers from 10.0.0.1, at most 2 at once" {
+		t.Error(fmt.Sprintf("expected the third session of a client to be refused got %v", err))
+	}
+
+	if scheduler.Acquire(b) != nil {
+		t.Error("expected another client to be allowed")
+	}
+
+	err = scheduler.Acquire(c)
+	if err == nil || err.Error() != "server busy, 3 transfers in progress, try again later" {
+		t.Error(fmt.Sprintf("expected a session over the total to be refused got %v", err))
+	}
+
+	active, client := scheduler.Active(a3)
+	if active != 3 || client != 2 {
+		t.Error(fmt.Sprintf("expected 3 sessions, 2 of the client got %d %d", active, client))
+	}
+
+	scheduler.Release(a1)
+	if scheduler.Acquire(c) != nil {
+		t.Error("expected a released session to make room")
+	}
+
+	scheduler.Release(a2)
+	scheduler.Release(b)
+	scheduler.Release(c)
+
+	active, client = scheduler.Active(a1)
+	if active != 0 || client != 0 || len(scheduler.clients) != 0 {
+		t.Error(fmt.Sprintf("expected no sessions got %d %d %v", active, client, scheduler.clients))
+	}
+}
+
+func TestSchedulerNoLimits(t *testing.T) {
+	scheduler := NewScheduler(0, 0)
+	addr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1000}
+
+	for i := 0; i < 100; i++ {
+		if scheduler.Acquire(addr) != nil {
+			t.Fatal("expected no limit")
+		}
+	}
+
+	var none *Scheduler
+	if none.Acquire(addr) != nil {
+		t.Error("expected a nil scheduler to allow every session")
+	}
+
+	none.Release(addr)
+}
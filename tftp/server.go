@@ -0,0 +1,359 @@
+package tftp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	minReadBackoff = 5 * time.Millisecond
+	maxReadBackoff = time.Second
+)
+
+//ErrServerClosed is returned by Serve and ListenAndServe once Shutdown is called.
+var ErrServerClosed = errors.New("tftp: server closed")
+
+//ErrTransferAborted is sent to the clients of the transfers Shutdown aborts.
+var ErrTransferAborted = NewTftpError(NotDefinedErrorCode, "server shutting down")
+
+//Server serves tftp requests with the settings of the options it was built with.
+type Server struct {
+	config TftpConfig
+
+	mutex sync.Mutex
+	listeners map[net.PacketConn]bool
+	closed bool
+	workers sync.WaitGroup
+
+	// listening is done once Shutdown is called, transfers once it gives up
+	// waiting for them
+	listening context.Context
+	stopListening context.CancelFunc
+	transfers context.Context
+	abort context.CancelFunc
+}
+
+//NewServer returns a server built from options, files are served from the
+//current directory unless WithFileSystem or WithBackend is given.
+func NewServer(options ...Option) *Server {
+	config := TftpConfig{fsroot: ".", fstmp: ".", retries: DefaultRetries, scheduler: NewScheduler(DefaultMaxSessions, 0)}
+	for _, option := range options {
+		option(&config)
+	}
+
+	listening, stopListening := context.WithCancel(context.Background())
+	transfers, abort := context.WithCancel(context.Background())
+
+	return &Server{config: config, listeners: map[net.PacketConn]bool{}, listening: listening, stopListening: stopListening, transfers: transfers, abort: abort}
+}
+
+//Config returns the settings the server was built with.
+func (s *Server) Config() Config {
+	return s.config
+}
+
+//ListenAndServe listens on the address set with WithAddress and serves requests
+//until Shutdown is called. An error binding the address is returned.
+func (s *Server) ListenAndServe() error {
+	address := net.JoinHostPort(s.config.GetTftpIP(), strconv.Itoa(s.config.GetTftpPort()))
+
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(conn)
+}
+
+//Serve reads requests from conn and serves each on a socket of its own until
+//Shutdown is called, conn is closed when Serve returns.
+func (s *Server) Serve(conn net.PacketConn) error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		conn.Close()
+		return ErrServerClosed
+	}
+
+	s.listeners[conn] = true
+
+	// counted until the last session is dispatched, so Shutdown waits for it
+	s.workers.Add(1)
+	defer s.workers.Done()
+
+	// a session is dispatched only while a worker is idle, busy holds a token
+	// for every session taken so none waits in sessions behind another.
+	workers := s.config.GetWorkers()
+	sessions := make(chan *session, workers)
+	busy := make(chan struct{}, workers)
+	for i := 0; i < workers; i++ {
+		s.workers.Add(1)
+		go func() {
+			defer s.workers.Done()
+			for session := range sessions {
+				handleSession(s.transfers, session, s.config)
+				<-busy
+			}
+		}()
+	}
+
+	s.mutex.Unlock()
+
+	dispatch := func(session *session) bool {
+		if workers > 0 {
+			select {
+			case busy <- struct{}{}:
+				sessions <- session
+				return true
+			default:
+				return false
+			}
+		}
+
+		s.workers.Add(1)
+		go func() {
+			defer s.workers.Done()
+			handleSession(s.transfers, session, s.config)
+		}()
+
+		return true
+	}
+
+	s.config.GetLogger().Printf("serving tftp on %s", conn.LocalAddr())
+
+	err := udpServer(s.listening, s.transfers, conn, dispatch, s.config)
+	close(sessions)
+	conn.Close()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.listeners, conn)
+	if s.closed {
+		return ErrServerClosed
+	}
+
+	return err
+}
+
+//Shutdown stops the server accepting requests and waits for the transfers in
+//progress to finish. Once ctx is done the transfers left are aborted and Shutdown
+//returns the error of ctx, the aborted transfers send their clients
+//ErrTransferAborted, kill their hook commands and discard their uploads in the
+//background. Calling Shutdown again waits for them.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	s.closed = true
+	s.stopListening()
+	for conn := range s.listeners {
+		conn.Close()
+	}
+	s.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.config.GetLogger().Printf("aborting the transfers in progress: %s", ctx.Err())
+	s.abort()
+
+	return ctx.Err()
+}
+
+//handleSession processes the IORequest of session and closes its connection, the
+//session is released from the scheduler. If ctx is done the session is refused.
+func handleSession(ctx context.Context, session *session, config Config) {
+	defer config.GetScheduler().Release(session.connection.RemoteAddr())
+
+	if ctx.Err() != nil {
+		SendError(session.connection, ErrTransferAborted)
+	} else if (session.ioRequest.isWrite) {
+		err := ProcessWriteRequest(session.connection, session.ioRequest, config)
+		if err != nil {
+			config.GetLogger().Printf("write of %s from %s failed: %s", session.ioRequest.filename, session.connection.RemoteAddr(), err)
+			SendError(session.connection, err)
+		}
+	} else {
+		err := ProcessReadRequest(session.connection, session.ioRequest, config)
+		if err != nil {
+			config.GetLogger().Printf("read of %s from %s failed: %s", session.ioRequest.filename, session.connection.RemoteAddr(), err)
+			SendError(session.connection, err)
+		}
+	}
+
+	session.connection.Close()
+}
+
+//udpServer reads requests from conn and passes them to dispatch, each session is
+//served on a socket of its own. A request over the limits of the scheduler, one
+//dispatch returns false for or one a socket cannot be opened for is refused with
+//an error at once. Transient errors reading from conn are retried after a delay
+//that doubles up to a second, any other error is returned. udpServer returns
+//ErrServerClosed once listening is done, the transfers of the sessions are
+//aborted once transfers is done.
+func udpServer(listening context.Context, transfers context.Context, conn net.PacketConn, dispatch func(*session) bool, config Config) error {
+	logger := config.GetLogger()
+
+	ioRequestBuf := make([]byte, maxIOrequestBufSize)
+
+	// sessions are served from the address requests are received on
+	childAddress := ""
+	if ip := AddrIP(conn.LocalAddr()); ip != nil && !ip.IsUnspecified() {
+		childAddress = ip.String()
+	}
+
+	backoff := time.Duration(0)
+
+	for {
+		numBytes, addr, err := conn.ReadFrom(ioRequestBuf)
+
+		// a listener closed by Shutdown may fail with any error
+		if listening.Err() != nil {
+			return ErrServerClosed
+		}
+
+		if errors.Is(err, net.ErrClosed) {
+			return err
+		}
+
+		if err != nil && !isTransientReadError(err) {
+			logger.Printf("error reading from the tftp listener %s, no longer serving it: %s", conn.LocalAddr(), err)
+			return err
+		}
+
+		if err != nil {
+			backoff = readBackoff(backoff)
+			logger.Printf("error reading from the tftp listener %s, retrying in %s: %s", conn.LocalAddr(), backoff, err)
+
+			select {
+			case <-time.After(backoff):
+			case <-listening.Done():
+				return ErrServerClosed
+			}
+
+			continue
+		}
+
+		backoff = 0
+
+		ports := config.GetPortRange()
+		connServ, err := ports.Listen(childAddress)
+		if err != nil {
+			logger.Printf("Rejecting session for remote: %s on listener %s, unable to open a socket on %q: %s", addr, conn.LocalAddr(), childAddress, err)
+
+			// the error is sent from the listener, there is no socket for the session
+			listener := &UDPConnection{addr: addr, conn: conn, writeTimeout: uint64(time.Second)}
+			SendError(listener, NewTftpError(NotDefinedErrorCode, "server unable to open a socket for the transfer, try again later"))
+
+			continue
+		}
+
+		// a range nearly used up is reported as sessions are opened
+		if used, size := ports.Usage(); ports != nil && used * 10 >= size * 9 {
+			logger.Printf("session ports %s nearly exhausted: %d of %d in use", ports, used, size)
+		}
+
+		connection := NewUDPConnection(transfers, addr, connServ, 8 * time.Second, config.GetTimeout(), logger)
+
+		ioRequest, err := ParseIORequest(ioRequestBuf[:numBytes])
+		if err != nil {
+			logger.Printf("%s from %s: %s", err, addr, ioRequest.filename)
+			SendError(connection, IllegalOperation(err))
+			connection.Close()
+
+			continue
+		}
+
+		ioRequest.filename, err = config.GetRewriteRules().Rewrite(ioRequest.filename, ioRequest.isWrite, addr, logger)
+		if err != nil {
+			logger.Printf("%s from %s", err, addr)
+			SendError(connection, err)
+			connection.Close()
+
+			continue
+		}
+
+		err = config.GetMode().Check(ioRequest.filename, ioRequest.isWrite)
+		if err != nil {
+			logger.Printf("%s from %s", err, addr)
+			SendError(connection, err)
+			connection.Close()
+
+			continue
+		}
+
+		err = config.GetACL().Check(ioRequest.filename, ioRequest.isWrite, addr, logger)
+		if err != nil {
+			SendError(connection, err)
+			connection.Close()
+
+			continue
+		}
+
+		err = config.GetScheduler().Acquire(addr)
+		if err != nil {
+			logger.Printf("Rejecting session for remote: %s, filename: %s, write: %v: %s", addr, ioRequest.filename, ioRequest.isWrite, err)
+			SendError(connection, err)
+			connection.Close()
+
+			continue
+		}
+
+		session := &session{connection, ioRequest}
+
+		if !dispatch(session) {
+			logger.Printf("Rejecting session for remote: %s, filename: %s, write: %v: no idle worker", addr, ioRequest.filename, ioRequest.isWrite)
+			config.GetScheduler().Release(addr)
+			SendError(connection, NewTftpError(NotDefinedErrorCode, "server busy, try again later"))
+			connection.Close()
+
+			continue
+		}
+
+		logger.Printf("Processing session for remote: %s, local: %s, filename: %s, write: %v, mode: %s", addr, connServ.LocalAddr(), ioRequest.filename, ioRequest.isWrite, ioRequest.mode)
+	}
+}
+
+//isTransientReadError returns whether a read from a listener failed for a reason
+//that may pass, such as the system running out of buffers or an icmp error for
+//an earlier packet.
+func isTransientReadError(err error) bool {
+	if IsTimeout(err) {
+		return true
+	}
+
+	for _, errno := range []syscall.Errno{syscall.EINTR, syscall.EAGAIN, syscall.ENOBUFS, syscall.ENOMEM, syscall.ECONNREFUSED, syscall.ECONNRESET} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//readBackoff returns the delay before reading again after a read error, the
+//previous delay doubled between minReadBackoff and maxReadBackoff.
+func readBackoff(previous time.Duration) time.Duration {
+	if previous < minReadBackoff {
+		return minReadBackoff
+	}
+
+	if previous * 2 > maxReadBackoff {
+		return maxReadBackoff
+	}
+
+	return previous * 2
+}
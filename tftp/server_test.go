@@ -0,0 +1,526 @@
+package tftp
+
+import (
+	"context"
+	"io"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestServerServe(t *testing.T) {
+	backend := NewMemoryBackend()
+	backend.Store("hello.txt", []byte("hello world\n"))
+
+	server := NewServer(WithBackend(backend), WithTimeout(time.Second))
+
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	client.WriteTo(append([]byte{0, 1}, "hello.txt\x00octet\x00"...), listener.LocalAddr())
+
+	buf := make([]byte, maxReplySize)
+	numBytes, session, err := client.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := ParseDataBlock(buf[:numBytes])
+	if err != nil || block.blockNumber != 1 || string(block.data) != "hello world\n" {
+		t.Error(fmt.Sprintf("expected the first block of hello.txt got %v", buf[:numBytes]))
+	}
+
+	if sameUDPAddr(session, listener.LocalAddr()) {
+		t.Error("expected the transfer to be served from a socket of its own")
+	}
+
+	client.WriteTo([]byte{0, 4, 0, 1}, session)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+	defer cancel()
+
+	err = server.Shutdown(ctx)
+	if err != nil {
+		t.Error(fmt.Sprintf("expected the transfer to finish got %s", err))
+	}
+
+	if err := <-served; err != ErrServerClosed {
+		t.Error(fmt.Sprintf("expected %s got %v", ErrServerClosed, err))
+	}
+}
+
+func TestServerShutdownAbort(t *testing.T) {
+	root := t.TempDir()
+	tmp := t.TempDir()
+
+	server := NewServer(WithFileSystem(root, tmp), WithTimeout(time.Second))
+
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	client.WriteTo(append([]byte{0, 2}, "firmware.bin\x00octet\x00"...), listener.LocalAddr())
+
+	buf := make([]byte, maxReplySize)
+	numBytes, session, err := client.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ack, err := ParseAck(buf[:numBytes])
+	if err != nil || ack.blockNumber != 0 {
+		t.Fatal(fmt.Sprintf("expected ack 0 got %v", buf[:numBytes]))
+	}
+
+	// the first block of the upload is sent, the rest never is
+	client.WriteTo(append([]byte{0, 3, 0, 1}, PatternData(512)...), session)
+
+	_, _, err = client.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100 * time.Millisecond)
+	defer cancel()
+
+	err = server.Shutdown(ctx)
+	if err != context.DeadlineExceeded {
+		t.Error(fmt.Sprintf("expected the transfer to be aborted got %v", err))
+	}
+
+	numBytes, _, err = client.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tftpErr, err := ParseTftpErrorSlice(buf[:numBytes])
+	if err != nil || tftpErr.errMsg != ErrTransferAborted.errMsg {
+		t.Error(fmt.Sprintf("expected %s got %v", ErrTransferAborted, buf[:numBytes]))
+	}
+
+	for _, dir := range []string{root, tmp} {
+		entries, _ := os.ReadDir(dir)
+		if len(entries) != 0 {
+			t.Error(fmt.Sprintf("expected the upload to be discarded from %s got %v", dir, entries))
+		}
+	}
+
+	if err := <-served; err != ErrServerClosed {
+		t.Error(fmt.Sprintf("expected %s got %v", ErrServerClosed, err))
+	}
+}
+
+//startServer serves a memory backend holding hello.txt on a port of 127.0.0.1
+//until the test ends.
+func startServer(t *testing.T, options ...Option) net.Addr {
+	backend := NewMemoryBackend()
+	backend.Store("hello.txt", []byte("hello world\n"))
+
+	server := NewServer(append([]Option{WithBackend(backend), WithTimeout(time.Second)}, options...)...)
+
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go server.Serve(listener)
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 100 * time.Millisecond)
+		defer cancel()
+
+		server.Shutdown(ctx)
+	})
+
+	return listener.LocalAddr()
+}
+
+//requestHello sends a read request for hello.txt from client and returns the
+//reply, the first block or an error.
+func requestHello(t *testing.T, client net.PacketConn, server net.Addr) []byte {
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	client.WriteTo(append([]byte{0, 1}, "hello.txt\x00octet\x00"...), server)
+
+	buf := make([]byte, maxReplySize)
+	numBytes, _, err := client.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf[:numBytes]
+}
+
+func listenClient(t *testing.T, ip string) net.PacketConn {
+	client, err := net.ListenPacket("udp", net.JoinHostPort(ip, "0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestServerNoIdleWorker(t *testing.T) {
+	server := startServer(t, WithWorkers(1))
+
+	// the only worker waits for the ack of the first block
+	reply := requestHello(t, listenClient(t, "127.0.0.1"), server)
+	if opcode, _ := ParseOpcode(reply); opcode != dataBlockOpcode {
+		t.Fatal(fmt.Sprintf("expected the first block got %v", reply))
+	}
+
+	reply = requestHello(t, listenClient(t, "127.0.0.1"), server)
+	tftpErr, err := ParseTftpErrorSlice(reply)
+	if err != nil || tftpErr.errMsg != "server busy, try again later" {
+		t.Error(fmt.Sprintf("expected the request to be refused got %v", reply))
+	}
+}
+
+func TestServerMaxSessionsPerClient(t *testing.T) {
+	server := startServer(t, WithMaxSessions(0, 1))
+
+	reply := requestHello(t, listenClient(t, "127.0.0.1"), server)
+	if opcode, _ := ParseOpcode(reply); opcode != dataBlockOpcode {
+		t.Fatal(fmt.Sprintf("expected the first block got %v", reply))
+	}
+
+	reply = requestHello(t, listenClient(t, "127.0.0.1"), server)
+	tftpErr, err := ParseTftpErrorSlice(reply)
+	if err != nil || tftpErr.errMsg != "too many transfers from 127.0.0.1, at most 1 at once" {
+		t.Error(fmt.Sprintf("expected the second session of the client to be refused got %v", reply))
+	}
+
+	reply = requestHello(t, listenClient(t, "127.0.0.2"), server)
+	if opcode, _ := ParseOpcode(reply); opcode != dataBlockOpcode {
+		t.Error(fmt.Sprintf("expected another client to be served got %v", reply))
+	}
+}
+
+//faultyListener fails the first failures reads, and reports localAddr as its
+//address if set.
+type faultyListener struct {
+	net.PacketConn
+	failures int
+	localAddr net.Addr
+}
+
+func (f *faultyListener) ReadFrom(buf []byte) (int, net.Addr, error) {
+	if f.failures > 0 {
+		f.failures = f.failures-1
+		return 0, nil, &net.OpError{Op: "read", Net: "udp", Err: os.NewSyscallError("recvfrom", syscall.ENOBUFS)}
+	}
+
+	return f.PacketConn.ReadFrom(buf)
+}
+
+func (f *faultyListener) LocalAddr() net.Addr {
+	if f.localAddr != nil {
+		return f.localAddr
+	}
+
+	return f.PacketConn.LocalAddr()
+}
+
+func serveFaulty(t *testing.T, listener *faultyListener) {
+	backend := NewMemoryBackend()
+	backend.Store("hello.txt", []byte("hello world\n"))
+
+	server := NewServer(WithBackend(backend), WithTimeout(time.Second))
+	go server.Serve(listener)
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 100 * time.Millisecond)
+		defer cancel()
+
+		server.Shutdown(ctx)
+	})
+}
+
+func TestServerReadErrors(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serveFaulty(t, &faultyListener{PacketConn: conn, failures: 3})
+
+	reply := requestHello(t, listenClient(t, "127.0.0.1"), conn.LocalAddr())
+	if opcode, _ := ParseOpcode(reply); opcode != dataBlockOpcode {
+		t.Error(fmt.Sprintf("expected requests to be served after read errors got %v", reply))
+	}
+}
+
+//eofListener reports io.EOF in place of net.ErrClosed once it is closed, and
+//fails with io.EOF at once if broken.
+type eofListener struct {
+	net.PacketConn
+	broken bool
+}
+
+func (e *eofListener) ReadFrom(buf []byte) (int, net.Addr, error) {
+	if e.broken {
+		return 0, nil, io.EOF
+	}
+
+	numBytes, addr, err := e.PacketConn.ReadFrom(buf)
+	if err != nil {
+		return 0, nil, io.EOF
+	}
+
+	return numBytes, addr, nil
+}
+
+func TestServerShutdownListenerError(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewServer(WithBackend(NewMemoryBackend()))
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(&eofListener{PacketConn: conn})
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200 * time.Millisecond)
+	defer cancel()
+
+	err = server.Shutdown(ctx)
+	if err != nil {
+		t.Error(fmt.Sprintf("expected the server to stop got %s", err))
+	}
+
+	if err := <-served; err != ErrServerClosed {
+		t.Error(fmt.Sprintf("expected %s got %v", ErrServerClosed, err))
+	}
+}
+
+func TestServerPermanentReadError(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewServer(WithBackend(NewMemoryBackend()))
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(&eofListener{PacketConn: conn, broken: true})
+	}()
+
+	select {
+	case err := <-served:
+		if err != io.EOF {
+			t.Error(fmt.Sprintf("expected %s got %v", io.EOF, err))
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("expected a permanent read error to stop the server")
+	}
+}
+
+func TestServerSessionSocketError(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// sessions are bound on the address of the listener, which is not local
+	serveFaulty(t, &faultyListener{PacketConn: conn, localAddr: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 69}})
+
+	for i := 0; i < 2; i++ {
+		reply := requestHello(t, listenClient(t, "127.0.0.1"), conn.LocalAddr())
+		tftpErr, err := ParseTftpErrorSlice(reply)
+		if err != nil || tftpErr.errMsg != "server unable to open a socket for the transfer, try again later" {
+			t.Error(fmt.Sprintf("expected the request to be refused got %v", reply))
+		}
+	}
+}
+
+func TestIsTransientReadError(t *testing.T) {
+	transient := []error{
+		os.NewSyscallError("recvfrom", syscall.ENOBUFS),
+		&net.OpError{Op: "read", Net: "udp", Err: os.NewSyscallError("recvfrom", syscall.ECONNREFUSED)},
+		mockTimeoutError{},
+	}
+
+	for _, err := range transient {
+		if !isTransientReadError(err) {
+			t.Error(fmt.Sprintf("expected %s to be transient", err))
+		}
+	}
+
+	for _, err := range []error{io.EOF, os.NewSyscallError("recvfrom", syscall.EBADF)} {
+		if isTransientReadError(err) {
+			t.Error(fmt.Sprintf("expected %s to be permanent", err))
+		}
+	}
+}
+
+func TestReadBackoff(t *testing.T) {
+	expected := []time.Duration{5 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond}
+
+	backoff := time.Duration(0)
+	for _, delay := range expected {
+		backoff = readBackoff(backoff)
+		if backoff != delay {
+			t.Error(fmt.Sprintf("expected %s got %s", delay, backoff))
+		}
+	}
+
+	if readBackoff(800 * time.Millisecond) != time.Second || readBackoff(time.Second) != time.Second {
+		t.Error("expected the backoff to stop at a second")
+	}
+}
+
+func TestServerPortRange(t *testing.T) {
+	ports := freePorts(t, 2)
+	server := startServer(t, WithPortRange(ports))
+
+	for i := 0; i < 2; i++ {
+		client := listenClient(t, "127.0.0.1")
+		client.SetDeadline(time.Now().Add(5 * time.Second))
+		client.WriteTo(append([]byte{0, 1}, "hello.txt\x00octet\x00"...), server)
+
+		buf := make([]byte, maxReplySize)
+		_, session, err := client.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		port := session.(*net.UDPAddr).Port
+		if port < ports.first || port > ports.last {
+			t.Error(fmt.Sprintf("expected a session port in %s got %d", ports, port))
+		}
+	}
+
+	// both ports are held by sessions waiting for an ack
+	reply := requestHello(t, listenClient(t, "127.0.0.1"), server)
+	tftpErr, err := ParseTftpErrorSlice(reply)
+	if err != nil || tftpErr.errMsg != "server unable to open a socket for the transfer, try again later" {
+		t.Error(fmt.Sprintf("expected the request to be refused got %v", reply))
+	}
+}
+
+func TestServerShutdownHungValidator(t *testing.T) {
+	hooks := NewHooks()
+	hooks.Validate(CommandValidator("sleep 30"))
+
+	backend := NewMemoryBackend()
+	server := NewServer(WithBackend(backend), WithTimeout(time.Second), WithHooks(hooks))
+
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go server.Serve(listener)
+
+	client := listenClient(t, "127.0.0.1")
+	client.SetDeadline(time.Now().Add(10 * time.Second))
+	client.WriteTo(append([]byte{0, 2}, "firmware.bin\x00octet\x00"...), listener.LocalAddr())
+
+	buf := make([]byte, maxReplySize)
+	_, session, err := client.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the only block is final, the validator runs before it is acked
+	client.WriteTo(append([]byte{0, 3, 0, 1}, "firmware"...), session)
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100 * time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = server.Shutdown(ctx)
+	if err != context.DeadlineExceeded || time.Since(start) > time.Second {
+		t.Error(fmt.Sprintf("expected Shutdown to return at its deadline got %v after %s", err, time.Since(start)))
+	}
+
+	numBytes, _, err := client.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opcode, _ := ParseOpcode(buf[:numBytes]); opcode != errorOpcode {
+		t.Error(fmt.Sprintf("expected the upload to be refused got %v", buf[:numBytes]))
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5 * time.Second)
+	defer cancel()
+
+	if server.Shutdown(ctx) != nil {
+		t.Error("expected the aborted transfer to finish")
+	}
+
+	if _, ok := backend.Load("firmware.bin"); ok {
+		t.Error("expected the aborted upload to be discarded")
+	}
+}
+
+func TestServerServeAfterShutdown(t *testing.T) {
+	server := NewServer()
+
+	err := server.Shutdown(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.Serve(listener); err != ErrServerClosed {
+		t.Error(fmt.Sprintf("expected %s got %v", ErrServerClosed, err))
+	}
+}
+
+func TestServerListenAndServeBindError(t *testing.T) {
+	taken, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	port := taken.LocalAddr().(*net.UDPAddr).Port
+	server := NewServer(WithAddress("127.0.0.1", port))
+
+	if server.ListenAndServe() == nil {
+		t.Error("expected an error listening on a port in use")
+	}
+}
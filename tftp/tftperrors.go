@@ -0,0 +1,76 @@
+package tftp
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"syscall"
+)
+
+//Error codes of rfc1350 section 5, option negotiation failures are from rfc2347.
+const (
+	NotDefinedErrorCode uint16 = iota
+	FileNotFoundErrorCode
+	AccessViolationErrorCode
+	DiskFullErrorCode
+	IllegalOperationErrorCode
+	UnknownTransferIdErrorCode
+	FileExistsErrorCode
+	NoSuchUserErrorCode
+	OptionNegotiationErrorCode
+)
+
+//NewTftpError returns a TftpError with a formatted message.
+func NewTftpError(errorCode uint16, format string, args ...interface{}) TftpError {
+	return TftpError{errorCode, fmt.Sprintf(format, args...)}
+}
+
+//IllegalOperation reports a packet that does not fit the state of the transfer.
+func IllegalOperation(err error) TftpError {
+	return TftpError{IllegalOperationErrorCode, err.Error()}
+}
+
+//FileError translates an error from opening, creating or writing filename into
+//the TftpError sent to the client. The message names the file as the client
+//requested it, it never contains the path on the server.
+func FileError(err error, filename string) TftpError {
+	var tftpError TftpError
+	if errors.As(err, &tftpError) {
+		return tftpError
+	}
+
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return NewTftpError(FileNotFoundErrorCode, "file not found: %s", filename)
+	case errors.Is(err, fs.ErrPermission):
+		return NewTftpError(AccessViolationErrorCode, "access violation: %s", filename)
+	case errors.Is(err, fs.ErrExist):
+		return NewTftpError(FileExistsErrorCode, "file already exists: %s", filename)
+	case errors.Is(err, syscall.ENOSPC), errors.Is(err, syscall.EDQUOT):
+		return NewTftpError(DiskFullErrorCode, "disk full or allocation exceeded: %s", filename)
+	case errors.Is(err, syscall.EISDIR), errors.Is(err, syscall.ENOTDIR):
+		return NewTftpError(FileNotFoundErrorCode, "not a file: %s", filename)
+	}
+
+	return NewTftpError(NotDefinedErrorCode, "error accessing %s", filename)
+}
+
+//ToTftpError translates the error a transfer failed with into the TftpError sent
+//to the client, ok is false if no error packet should be sent.
+func ToTftpError(err error) (tftpError TftpError, ok bool) {
+	var remoteError RemoteError
+	if errors.As(err, &remoteError) {
+		return TftpError{}, false
+	}
+
+	if errors.As(err, &tftpError) {
+		return tftpError, true
+	}
+
+	var pathError *fs.PathError
+	if errors.As(err, &pathError) {
+		return FileError(err, "file"), true
+	}
+
+	return TftpError{NotDefinedErrorCode, err.Error()}, true
+}
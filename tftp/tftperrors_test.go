@@ -0,0 +1,60 @@
+package tftp
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestFileErrorCodes(t *testing.T) {
+
+	cases := []struct {
+		err error
+		errorCode uint16
+	}{
+		{&os.PathError{Op:"open", Path:"/srv/tftp/a", Err:syscall.ENOENT}, FileNotFoundErrorCode},
+		{&os.PathError{Op:"open", Path:"/srv/tftp/a", Err:syscall.EACCES}, AccessViolationErrorCode},
+		{&os.PathError{Op:"open", Path:"/srv/tftp/a", Err:syscall.EEXIST}, FileExistsErrorCode},
+		{&os.PathError{Op:"write", Path:"/srv/tftp/a", Err:syscall.ENOSPC}, DiskFullErrorCode},
+		{NewTftpError(OptionNegotiationErrorCode, "no"), OptionNegotiationErrorCode},
+		{errors.New("unexpected"), NotDefinedErrorCode},
+	}
+
+	for _, c := range cases {
+		tftpErr := FileError(c.err, "a")
+		if tftpErr.GetCode() != c.errorCode {
+			t.Error(fmt.Sprintf("%v: expected error code %d got %d", c.err, c.errorCode, tftpErr.GetCode()))
+		}
+	}
+
+}
+
+func TestFileErrorHidesServerPath(t *testing.T) {
+
+	tftpErr := FileError(&os.PathError{Op:"open", Path:"/srv/tftp/a", Err:syscall.ENOENT}, "a")
+	if tftpErr.errMsg != "file not found: a" {
+		t.Error(fmt.Sprintf("unexpected error message %q", tftpErr.errMsg))
+	}
+
+}
+
+func TestToTftpError(t *testing.T) {
+
+	_, ok := ToTftpError(RemoteError{TftpError{OptionNegotiationErrorCode, "no"}})
+	if ok {
+		t.Error("an error packet would be sent in reply to a remote error")
+	}
+
+	tftpErr, ok := ToTftpError(IllegalOperation(errors.New("Invalid opcode")))
+	if !ok || tftpErr.errorCode != IllegalOperationErrorCode {
+		t.Error(fmt.Sprintf("expected illegal operation got %v", tftpErr))
+	}
+
+	tftpErr, ok = ToTftpError(&os.PathError{Op:"open", Path:"/srv/tftp/a", Err:syscall.ENOENT})
+	if !ok || tftpErr.errorCode != FileNotFoundErrorCode {
+		t.Error(fmt.Sprintf("expected file not found got %v", tftpErr))
+	}
+
+}
@@ -0,0 +1,403 @@
+package tftp
+
+import (
+	"bytes"
+	"errors"
+	"encoding/binary"
+	"strings"
+)
+
+const (
+	_ = iota
+	readOpcode uint16 = iota
+	writeOpcode uint16 = iota
+	dataBlockOpcode uint16 = iota
+	ackOpcode uint16 = iota
+	errorOpcode uint16 = iota
+	oackOpcode uint16 = iota
+)
+
+const (
+	octetMode = "octet"
+	netasciiMode = "netascii"
+)
+
+type TftpRequest interface {
+	GetType() uint16
+}
+
+//Options is an ordered set of rfc2347 options, option names are case insensitive
+//and are stored in lower case.
+type Options struct {
+	names []string
+	values map[string]string
+}
+
+//Set adds the option or replaces the value of an option that is already present.
+func (o *Options) Set(name string, value string) {
+	name = strings.ToLower(name)
+
+	if o.values == nil {
+		o.values = make(map[string]string)
+	}
+
+	if _, ok := o.values[name]; !ok {
+		o.names = append(o.names, name)
+	}
+
+	o.values[name] = value
+}
+
+func (o Options) Get(name string) (string, bool) {
+	value, ok := o.values[strings.ToLower(name)]
+	return value, ok
+}
+
+//Names returns the option names in the order they were added.
+func (o Options) Names() []string {
+	return o.names
+}
+
+func (o Options) Len() int {
+	return len(o.names)
+}
+
+//encodedLength returns the number of bytes the options take up on the wire.
+func (o Options) encodedLength() int {
+	length := 0
+	for _, name := range o.names {
+		length = length + len(name) + 1 + len(o.values[name]) + 1
+	}
+
+	return length
+}
+
+//parseOptions reads null terminated name/value pairs until the buffer is empty.
+func parseOptions(buffer *bytes.Buffer) (Options, error) {
+	options := Options{}
+
+	for buffer.Len() > 0 {
+		nameBytes, err := buffer.ReadBytes((byte)(0))
+		if err != nil {
+			return Options{}, errors.New("option name is not null terminated")
+		}
+
+		if len(nameBytes) < 2 {
+			return Options{}, errors.New("request contains an empty option name")
+		}
+
+		valueBytes, err := buffer.ReadBytes((byte)(0))
+		if err != nil {
+			return Options{}, errors.New("option value is not null terminated")
+		}
+
+		name := string(nameBytes[:len(nameBytes)-1])
+		if _, ok := options.Get(name); ok {
+			continue
+		}
+
+		options.Set(name, string(valueBytes[:len(valueBytes)-1]))
+	}
+
+	return options, nil
+}
+
+//optionsToSlice writes the options as null terminated name/value pairs and returns
+//the number of bytes written.
+func optionsToSlice(options Options, optionsSlice []byte) int {
+	length := 0
+	for _, name := range options.names {
+		length = length + copy(optionsSlice[length:], name)
+		optionsSlice[length] = 0
+		length = length + 1
+
+		length = length + copy(optionsSlice[length:], options.values[name])
+		optionsSlice[length] = 0
+		length = length + 1
+	}
+
+	return length
+}
+
+type IORequest struct {
+	isWrite bool
+	filename string
+	mode string
+	options Options
+}
+
+func (i IORequest) GetType() uint16 {
+	if i.isWrite {
+		return writeOpcode
+	}
+
+	return readOpcode
+}
+
+func ParseIORequest(byteSlice []byte) (IORequest, error) {
+	if byteSlice == nil || len(byteSlice) < 4 {
+		return IORequest{}, errors.New("byteSlice parameter was nil")
+	}
+
+	isWrite := false
+	opcode := binary.BigEndian.Uint16(byteSlice[0:2])
+	if opcode == readOpcode {
+		isWrite = false
+	} else if opcode == writeOpcode {
+		isWrite = true
+	} else {
+		return IORequest{}, errors.New("Invalid opcode")
+	}
+
+	buffer := bytes.NewBuffer(byteSlice[2:len(byteSlice)])
+	filenameBytes, err := buffer.ReadBytes((byte)(0))
+	if err != nil {
+		return IORequest{}, err
+	}
+
+	filenameBytesLength := len(filenameBytes) - 1
+	if filenameBytesLength < 1 {
+		return IORequest{}, errors.New("request does not contain a filename")
+	}
+
+	filename := string(filenameBytes[:filenameBytesLength])
+
+	modeBytes, err := buffer.ReadBytes((byte)(0))
+	if err != nil {
+		return IORequest{}, err
+	}
+
+	modeBytesLength := len(modeBytes) - 1
+	if modeBytesLength < 1 {
+		return IORequest{}, errors.New("request does not contain a mode")
+	}
+
+	mode := strings.ToLower(string(modeBytes[:modeBytesLength]))
+
+	if mode != octetMode && mode != netasciiMode {
+		return IORequest{}, errors.New("cannot support modes other than octet and netascii")
+	}
+
+	options, err := parseOptions(buffer)
+	if err != nil {
+		return IORequest{}, err
+	}
+
+	return IORequest{isWrite, filename, mode, options}, nil
+}
+
+
+type DataBlock struct {
+	blockNumber uint16
+	data []byte
+}
+
+func (d DataBlock) GetType() uint16 {
+	return dataBlockOpcode
+}
+
+//IsFinal returns whether the data block is the last block of a transfer using
+//blocks of blockSize bytes.
+func (d DataBlock) IsFinal(blockSize int) bool {
+	if len(d.data) < blockSize {
+		return true
+	}
+
+	return false
+}
+
+//NextBlockNumber returns the block number that follows blockNumber, after 65535
+//block numbers roll over to rollover which is 0 or 1 depending on the client.
+func NextBlockNumber(blockNumber uint16, rollover uint16) uint16 {
+	if blockNumber == 65535 {
+		return rollover
+	}
+
+	return blockNumber+1
+}
+
+//PreviousBlockNumber is the inverse of NextBlockNumber.
+func PreviousBlockNumber(blockNumber uint16, rollover uint16) uint16 {
+	if blockNumber == rollover {
+		return 65535
+	}
+
+	return blockNumber-1
+}
+
+func ParseDataBlock(byteSlice []byte) (DataBlock, error) {
+	if byteSlice == nil || len(byteSlice) < 4 {
+		return DataBlock{}, errors.New("byteSlice parameter was nil or number of bytes in byteSlice is less than 4 for a data block")
+	}
+
+	opcode := binary.BigEndian.Uint16(byteSlice[0:2])
+	if opcode != dataBlockOpcode {
+		return DataBlock{}, errors.New("Invalid opcode")
+	}
+
+	blockNumber := binary.BigEndian.Uint16(byteSlice[2:4])
+	data := byteSlice[4:len(byteSlice)]
+
+	return DataBlock{blockNumber, data}, nil
+}
+
+
+func DataBlockToSlice(dataBlock DataBlock, dataBlockSlice []byte) int {
+
+	dataBlockLength := 4 + len(dataBlock.data)
+	binary.BigEndian.PutUint16(dataBlockSlice[0:2], dataBlockOpcode)
+	binary.BigEndian.PutUint16(dataBlockSlice[2:4], dataBlock.blockNumber)
+	copy(dataBlockSlice[4:dataBlockLength], dataBlock.data)
+
+	return dataBlockLength
+
+}
+
+
+type Ack struct {
+	blockNumber uint16
+}
+
+func (a Ack) GetType() uint16 {
+	return ackOpcode
+}
+
+func ParseAck(byteSlice []byte) (Ack, error) {
+	if byteSlice == nil || len(byteSlice) < 4 {
+		return Ack{}, errors.New("byteSlice parameter was nil or number of bytes in byteSlice is less than 4 for a data block")
+	}
+
+	opcode := binary.BigEndian.Uint16(byteSlice[0:2])
+
+	if opcode != ackOpcode {
+		return Ack{}, errors.New("Invalid opcode")
+	}
+
+	blockNumber := binary.BigEndian.Uint16(byteSlice[2:4])
+
+	return Ack{blockNumber}, nil
+}
+
+
+func AckToSlice(ack Ack, ackSlice []byte) int {
+
+	binary.BigEndian.PutUint16(ackSlice[0:2], ackOpcode)
+	binary.BigEndian.PutUint16(ackSlice[2:4], ack.blockNumber)
+
+	return 4
+
+}
+
+type TftpError struct {
+	errorCode uint16
+	errMsg string
+}
+
+func (e TftpError) GetType() uint16 {
+	return errorOpcode
+}
+
+func (e TftpError) Error() string {
+	return e.errMsg
+}
+
+//GetCode returns the error code sent in the error packet, one of the ErrorCode
+//constants.
+func (e TftpError) GetCode() uint16 {
+	return e.errorCode
+}
+
+func ParseTftpErrorSlice(byteSlice []byte) (TftpError, error) {
+	if byteSlice == nil || len(byteSlice) < 4 {
+		return TftpError{}, errors.New("byteSlice parameter was nil")
+	}
+
+	opcode := binary.BigEndian.Uint16(byteSlice[0:2])
+	if opcode != errorOpcode {
+		return TftpError{}, errors.New("Invalid opcode")
+	}
+
+	errorCode := binary.BigEndian.Uint16(byteSlice[2:4])
+
+	if len(byteSlice) == 4 {
+		return TftpError{errorCode, ""}, nil
+	}
+
+	buffer := bytes.NewBuffer(byteSlice[4:len(byteSlice)])
+	errorBytes, err := buffer.ReadBytes((byte)(0))
+	if err != nil {
+		return TftpError{}, err
+	}
+
+	errorBytesLength := len(errorBytes) - 1
+	if errorBytesLength < 1 {
+		return TftpError{errorCode, ""}, nil
+	}
+
+	errorString := string(errorBytes[:errorBytesLength])
+
+	return TftpError{errorCode, errorString}, nil
+
+}
+
+func ToTftpErrorSlice(tftpError TftpError, errorSlice []byte) int {
+	errorLength :=  4 + len(tftpError.errMsg) + 1
+
+	binary.BigEndian.PutUint16(errorSlice[0:2], errorOpcode)
+	binary.BigEndian.PutUint16(errorSlice[2:4], tftpError.errorCode)
+
+	copy(errorSlice[4:errorLength], []byte(tftpError.errMsg))
+
+	return errorLength
+
+}
+
+//OAck is the option acknowledgement sent in place of the first ACK or DATA packet
+//when the server accepts any of the options in a request.
+type OAck struct {
+	options Options
+}
+
+func (o OAck) GetType() uint16 {
+	return oackOpcode
+}
+
+//Length returns the number of bytes OAckToSlice writes.
+func (o OAck) Length() int {
+	return 2 + o.options.encodedLength()
+}
+
+func ParseOAck(byteSlice []byte) (OAck, error) {
+	if byteSlice == nil || len(byteSlice) < 2 {
+		return OAck{}, errors.New("byteSlice parameter was nil or number of bytes in byteSlice is less than 2 for an oack")
+	}
+
+	opcode := binary.BigEndian.Uint16(byteSlice[0:2])
+	if opcode != oackOpcode {
+		return OAck{}, errors.New("Invalid opcode")
+	}
+
+	options, err := parseOptions(bytes.NewBuffer(byteSlice[2:len(byteSlice)]))
+	if err != nil {
+		return OAck{}, err
+	}
+
+	return OAck{options}, nil
+}
+
+func OAckToSlice(oack OAck, oackSlice []byte) int {
+
+	binary.BigEndian.PutUint16(oackSlice[0:2], oackOpcode)
+
+	return 2 + optionsToSlice(oack.options, oackSlice[2:])
+
+}
+
+//ParseOpcode returns the opcode of the packet in byteSlice.
+func ParseOpcode(byteSlice []byte) (uint16, error) {
+	if byteSlice == nil || len(byteSlice) < 2 {
+		return 0, errors.New("byteSlice parameter was nil or number of bytes in byteSlice is less than 2 for an opcode")
+	}
+
+	return binary.BigEndian.Uint16(byteSlice[0:2]), nil
+}
@@ -1,4 +1,4 @@
-package main
+package tftp
 
 import (
 	"bytes"
@@ -109,6 +109,21 @@ func TestIORequestInvalidModeParseFailure(t *testing.T) {
 
 }
 
+func TestIORequestNetasciiModeParseSuccess(t *testing.T) {
+
+	byteSlice := []byte{0,2,'a','b','c',0,'N','e','t','A','S','C','I','I',0}
+
+	ioRequest, err := ParseIORequest(byteSlice)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if ioRequest.mode != netasciiMode {
+		t.Error(fmt.Sprintf("Expected %s for mode but was %s", netasciiMode, ioRequest.mode))
+	}
+
+}
+
 func TestDataBlockBasicParseSuccess(t *testing.T) {
 
 	byteSlice := []byte{0,3, 0,10, 0,0,0,1}
@@ -119,14 +134,14 @@ func TestDataBlockBasicParseSuccess(t *testing.T) {
 	}
 
 	if dataBlock.blockNumber != 10 {
-		t.Error(fmt.Sprintf("Expected block number 10, got %s", dataBlock.blockNumber))
+		t.Error(fmt.Sprintf("Expected block number 10, got %d", dataBlock.blockNumber))
 	}
 
 	if bytes.Compare(byteSlice[4:len(byteSlice)], dataBlock.data) != 0 {
 		t.Error("data block parse error, data mismatch")
 	}
 
-	if !dataBlock.IsFinal() {
+	if !dataBlock.IsFinal(512) {
 		t.Error("data block length is less than 512 but was not considered as final")
 	}
 }
@@ -191,7 +206,7 @@ func TestAckBasicParseSuccess(t *testing.T) {
 	}
 
 	if ack.blockNumber != 10 {
-		t.Error(fmt.Sprintf("Expected block number 10, got %s", ack.blockNumber))
+		t.Error(fmt.Sprintf("Expected block number 10, got %d", ack.blockNumber))
 	}
 
 }
@@ -255,7 +270,7 @@ func TestTftpErrorBasicParseSuccess(t *testing.T) {
 	}
 
 	if tftpErr.errorCode != 10 {
-		t.Error(fmt.Sprintf("Expected error code 10, got %s", tftpErr.errorCode))
+		t.Error(fmt.Sprintf("Expected error code 10, got %d", tftpErr.errorCode))
 	}
 
 }
@@ -358,3 +373,77 @@ func TestTftpErrorBasicToSliceSuccess(t *testing.T) {
 	}
 
 }
+
+func TestIORequestOptionsParseSuccess(t *testing.T) {
+
+	byteSlice := []byte{0,1,'a','b','c',0,'o','c','t','e','t',0,'B','l','k','S','i','z','e',0,'1','4','2','8',0,'t','s','i','z','e',0,'0',0}
+
+	ioRequest, err := ParseIORequest(byteSlice)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if ioRequest.options.Len() != 2 {
+		t.Error(fmt.Sprintf("Expected 2 options but got %d", ioRequest.options.Len()))
+	}
+
+	if fmt.Sprint(ioRequest.options.Names()) != "[blksize tsize]" {
+		t.Error(fmt.Sprintf("Expected options in request order but got %v", ioRequest.options.Names()))
+	}
+
+	value, ok := ioRequest.options.Get("BLKSIZE")
+	if !ok || value != "1428" {
+		t.Error(fmt.Sprintf("Expected blksize 1428 but got %s", value))
+	}
+
+}
+
+func TestIORequestIncompleteOptionParseFailure(t *testing.T) {
+
+	byteSlice := []byte{0,1,'a','b','c',0,'o','c','t','e','t',0,'t','s','i','z','e',0,'0'}
+
+	_, err := ParseIORequest(byteSlice)
+	if err == nil {
+		t.Error("Expected error on option value without delimiter but didn't get an error")
+	}
+
+}
+
+func TestOAckBasicToSliceSuccess(t *testing.T) {
+
+	expectedOAckBytes := []byte{0,6,'b','l','k','s','i','z','e',0,'1','4','2','8',0,'t','s','i','z','e',0,'1','0',0}
+
+	options := Options{}
+	options.Set("blksize", "1428")
+	options.Set("tsize", "10")
+	oack := OAck{options}
+
+	oackSlice := make([]byte, oack.Length())
+	numBytes := OAckToSlice(oack, oackSlice)
+
+	if bytes.Compare(oackSlice[:numBytes], expectedOAckBytes) != 0 {
+		t.Error("serialized oack does not match the expected oack bytes")
+	}
+
+	parsed, err := ParseOAck(oackSlice)
+	if err != nil {
+		t.Error(err)
+	}
+
+	value, ok := parsed.options.Get("tsize")
+	if parsed.options.Len() != 2 || !ok || value != "10" {
+		t.Error("parsed oack does not match the serialized options")
+	}
+
+}
+
+func TestOAckOpcodeParseFailure(t *testing.T) {
+
+	byteSlice := []byte{0,4,0,1}
+
+	_, err := ParseOAck(byteSlice)
+	if err == nil {
+		t.Error("Expected error parsing opcode, didn't get an error")
+	}
+
+}
@@ -0,0 +1,505 @@
+package tftp
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"io"
+)
+
+type session struct {
+	connection *UDPConnection
+	ioRequest IORequest
+}
+
+//openRead opens the file a read request is for, generated by a handler if one
+//matches the filename and read from the backend otherwise. The size is -1 if it
+//is not known.
+func openRead(conn Connection, readRequest IORequest, config Config) (io.Reader, int64, error) {
+	filename, err := CleanFilename(readRequest.filename)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	handler, ok := config.GetHandlers().MatchRead(filename)
+	if ok {
+		return handler(NewRequest(conn, filename, readRequest))
+	}
+
+	file, fileSize, err := config.GetBackend().Open(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return sectionFile{io.NewSectionReader(file, 0, fileSize), file}, fileSize, nil
+}
+
+//sectionFile reads a file opened by a backend from the start and closes it.
+type sectionFile struct {
+	*io.SectionReader
+	io.Closer
+}
+
+//uploadBackend returns the backend a write request is staged in, a handler
+//takes the upload if one matches the filename.
+func uploadBackend(conn Connection, writeRequest IORequest, config Config) Backend {
+	filename, err := CleanFilename(writeRequest.filename)
+	if err != nil {
+		return config.GetBackend()
+	}
+
+	handler, ok := config.GetHandlers().MatchWrite(filename)
+	if ok {
+		return streamBackend{handler, NewRequest(conn, filename, writeRequest)}
+	}
+
+	return config.GetBackend()
+}
+
+//newUpload describes the size bytes staged in file to hooks, digest has been
+//written the content of the file. The size of a netascii upload is the size once
+//decoded.
+func newUpload(conn Connection, writeRequest IORequest, file StagedFile, size int64, digest hash.Hash) *Upload {
+	upload := &Upload{
+		Filename: writeRequest.filename,
+		Path: writeRequest.filename,
+		RemoteAddr: conn.RemoteAddr(),
+		Size: size,
+		Digest: hex.EncodeToString(digest.Sum(nil)),
+		ctx: conn.Context(),
+	}
+
+	if file, ok := file.(interface{ Path() string }); ok {
+		upload.Path = file.Path()
+	}
+
+	if file, ok := file.(interface{ StagedPath() string }); ok {
+		upload.StagedPath = file.StagedPath()
+	}
+
+	if file, ok := file.(io.ReaderAt); ok {
+		upload.content = file
+	}
+
+	return upload
+}
+
+//replacedSize returns the size of the file an upload of filename replaces in
+//backend, 0 if there is none or it is kept as a backup.
+func replacedSize(backend Backend, filename string, config Config) int64 {
+	if _, ok := backend.(streamBackend); ok || config.GetUploadBackups() {
+		return 0
+	}
+
+	file, size, err := backend.Open(filename)
+	if err != nil {
+		return 0
+	}
+
+	file.Close()
+	return max(size, 0)
+}
+
+/*
+Read State Machine:
+
+1. Incoming Connection.
+2. Read Request contains file name / mode / options.
+3. If any options were accepted send OACK and receive Ack DataBlockNumber 0, the client may reject the options with error 8.
+4. Send DataBlockNumbers i to i+windowsize-1.
+5. Receive Ack DataBlockNumber j within the window, ignore any other Ack without restarting the timeout. On timeout re-send the window. if retries > x, send error, close conn.
+6. If remaining data, Goto Step 4 with i = j+1, else exit.
+*/
+func ProcessReadRequest(conn Connection, readRequest IORequest, config Config) error {
+
+	// block numbers on the wire roll over after 65535, blockIndex and offset
+	// count the blocks and bytes acknowledged over the whole transfer.
+	nextBlockNumber := uint16(1)
+	blockIndex := uint64(0)
+	offset := int64(0)
+
+	ackBuf := make([]byte, maxReplySize)
+
+	final := false
+
+	reader, fileSize, err := openRead(conn, readRequest, config)
+	if err != nil {
+		return FileError(err, readRequest.filename)
+	}
+
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	// the size of a file sent as netascii is not known until it is encoded
+	if readRequest.mode == netasciiMode {
+		reader = NewNetasciiReader(reader)
+		fileSize = -1
+	}
+
+	transfer, oackOptions, err := NegotiateOptions(readRequest, fileSize, config)
+	if err != nil {
+		return err
+	}
+
+	if oackOptions.Len() > 0 {
+		oack := OAck{oackOptions}
+		oackBuf := make([]byte, oack.Length())
+		OAckToSlice(oack, oackBuf)
+
+		ackLength, err := transmit(conn, ackBuf, transfer, config, oackBuf)
+		if err != nil {
+			return err
+		}
+
+		err = checkRemoteError(ackBuf[:ackLength])
+		if err != nil {
+			return err
+		}
+
+		ack, err := ParseAck(ackBuf[:ackLength])
+		if err != nil {
+			return IllegalOperation(err)
+		}
+
+		if ack.blockNumber != 0 {
+			return NewTftpError(IllegalOperationErrorCode, "expected ack 0 for oack got %d", ack.blockNumber)
+		}
+	}
+
+	// window holds the data blocks sent but not yet acknowledged, the block after
+	// the last of them is nextBlockNumber. Buffers of acknowledged blocks are
+	// kept in spare.
+	window := make([][]byte, 0, transfer.windowSize)
+	spare := make([][]byte, 0, transfer.windowSize)
+
+	for {
+		for !final && len(window) < transfer.windowSize {
+			var dataBlockBuf []byte
+			if len(spare) > 0 {
+				dataBlockBuf = spare[len(spare)-1][:transfer.blockSize + 4]
+				spare = spare[:len(spare)-1]
+			} else {
+				dataBlockBuf = make([]byte, transfer.blockSize + 4)
+			}
+
+			numBytes, err := io.ReadFull(reader, dataBlockBuf[4:])
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				return FileError(err, readRequest.filename)
+			}
+
+			dataBlock := DataBlock{nextBlockNumber, dataBlockBuf[4:4+numBytes]}
+			numBytes = DataBlockToSlice(dataBlock, dataBlockBuf)
+
+			window = append(window, dataBlockBuf[:numBytes])
+			final = dataBlock.IsFinal(transfer.blockSize)
+			nextBlockNumber = NextBlockNumber(nextBlockNumber, config.GetBlockRollover())
+		}
+
+		// a duplicate ack is ignored rather than answered with the window
+		// again, the window is only re-sent on timeout. rfc1123 4.2.3.1
+		// (Sorcerer's Apprentice Syndrome).
+		inWindow := func(packet []byte) bool {
+			ack, err := ParseAck(packet)
+			return err != nil || ackedBlocks(window, ack.blockNumber) > 0
+		}
+
+		err = writePackets(conn, window)
+		if err != nil {
+			return err
+		}
+
+		ackLength, err := awaitReply(conn, ackBuf, transfer, config, inWindow, window...)
+		if err != nil {
+			return err
+		}
+
+		err = checkRemoteError(ackBuf[:ackLength])
+		if err != nil {
+			return err
+		}
+
+		ack, err := ParseAck(ackBuf[:ackLength])
+		if err != nil {
+			return IllegalOperation(err)
+		}
+
+		acked := ackedBlocks(window, ack.blockNumber)
+
+		for _, packet := range window[:acked] {
+			offset = offset + int64(len(packet) - 4)
+		}
+
+		blockIndex = blockIndex + uint64(acked)
+		spare = append(spare, window[:acked]...)
+		window = append(window[:0], window[acked:]...)
+
+		if final && len(window) == 0 {
+			break
+		}
+	}
+
+	config.GetLogger().Printf("read complete! %s %d blocks %d bytes", readRequest.filename, blockIndex, offset)
+	return nil
+}
+
+//ackedBlocks returns the number of blocks of window an ack of blockNumber
+//acknowledges, 0 if blockNumber is not in the window.
+func ackedBlocks(window [][]byte, blockNumber uint16) int {
+	for i, packet := range window {
+		if binary.BigEndian.Uint16(packet[2:4]) == blockNumber {
+			return i+1
+		}
+	}
+
+	return 0
+}
+
+/*
+Write State Machine:
+
+1. Incoming Connection.
+2. Write Request contains file name / mode / options.
+3. Send Ack DataBlockNumber i, or OACK in place of Ack DataBlockNumber 0 if any options were accepted.
+4. Receive DataBlockNumbers i+1 to i+windowsize. On timeout re-send Ack DataBlockNumber i. if retries > x, send error, close conn.
+   If a block arrives out of order or again Ack the last block received in order and continue from there.
+5. If datablock length < blksize, Goto step 3 and exit, else Goto Step 3 and repeat.
+6. Re-send the final Ack if the final block arrives again before a timeout.
+*/
+func ProcessWriteRequest(conn Connection, writeRequest IORequest, config Config) error {
+
+	// block numbers on the wire roll over after 65535, blockIndex and offset
+	// count the blocks and bytes received over the whole transfer.
+	dataBlockNumber := uint16(0)
+	blockIndex := uint64(0)
+	offset := int64(0)
+
+	ackBuf := make([]byte, 4)
+
+	transfer, oackOptions, err := NegotiateOptions(writeRequest, -1, config)
+	if err != nil {
+		return err
+	}
+
+	backend := uploadBackend(conn, writeRequest, config)
+
+	// the bytes of the file the upload replaces are credited before any are
+	// reserved from the quota, an announced tsize is checked against both.
+	quotas := config.GetQuotas()
+	credit := replacedSize(backend, writeRequest.filename, config)
+	if transfer.transferSize >= 0 {
+		err = quotas.Check(conn.RemoteAddr(), transfer.transferSize - credit)
+		if err != nil {
+			return err
+		}
+	}
+
+	// a byte over the largest packet allowed so that a larger one is not
+	// truncated to a full block by the read
+	dataBlockBuf := make([]byte, transfer.blockSize + 5)
+
+	oack := OAck{oackOptions}
+	oackBuf := make([]byte, oack.Length())
+	OAckToSlice(oack, oackBuf)
+
+	file, err := backend.Create(writeRequest.filename, UploadOptions{config.GetUploadPolicy(), config.GetUploadBackups()})
+	if err != nil {
+		return FileError(err, writeRequest.filename)
+	}
+
+	// the staged file is discarded unless the transfer gets as far as the commit,
+	// the bytes reserved from the quota are returned unless the commit succeeds.
+	committed := false
+	reserved := int64(0)
+	defer func() {
+		if !committed {
+			backend.Abort(file)
+		}
+
+		quotas.Release(conn.RemoteAddr(), reserved)
+	}()
+
+	// uploads taken by a handler are not staged and do not run hooks
+	hooks := config.GetHooks()
+	if _, ok := backend.(streamBackend); ok {
+		hooks = nil
+	}
+
+	var writer io.Writer = file
+	digest := sha256.New()
+	stored := &countingWriter{}
+	if hooks.Enabled() {
+		writer = io.MultiWriter(file, digest, stored)
+	}
+
+	var netasciiWriter io.WriteCloser
+	if writeRequest.mode == netasciiMode {
+		netasciiWriter = NewNetasciiWriter(writer)
+		writer = netasciiWriter
+	}
+
+	AckToSlice(Ack{dataBlockNumber}, ackBuf)
+
+	reply := ackBuf
+	if oackOptions.Len() > 0 {
+		reply = oackBuf
+	}
+
+	// received counts the blocks taken since reply was last sent, reply is sent
+	// again once a window has been received or a block arrives out of order.
+	received := 0
+	send := true
+	outOfOrder := false
+
+	for {
+		var numBytes int
+		if send {
+			numBytes, err = transmit(conn, dataBlockBuf, transfer, config, reply)
+		} else {
+			numBytes, err = await(conn, dataBlockBuf, transfer, config, reply)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		send = false
+
+		err = checkRemoteError(dataBlockBuf[:numBytes])
+		if err != nil {
+			return err
+		}
+
+		dataBlock, err := ParseDataBlock(dataBlockBuf[:numBytes])
+		if err != nil {
+			return IllegalOperation(err)
+		}
+
+		if len(dataBlock.data) > transfer.blockSize {
+			return NewTftpError(IllegalOperationErrorCode, "datablock %d exceeds the block size of %d bytes", dataBlock.blockNumber, transfer.blockSize)
+		}
+
+		expectedBlockNumber := NextBlockNumber(dataBlockNumber, config.GetBlockRollover())
+		if dataBlock.blockNumber != expectedBlockNumber {
+			duplicate := isRecentBlockNumber(dataBlock.blockNumber, dataBlockNumber, blockIndex, transfer.windowSize, config.GetBlockRollover())
+			if !duplicate && transfer.windowSize == 1 {
+				return NewTftpError(IllegalOperationErrorCode, "expected datablock %d got %d at block index %d", expectedBlockNumber, dataBlock.blockNumber, blockIndex+1)
+			}
+
+			// a duplicate block is not written again, the sender missed the
+			// last ack. A block ahead of the one expected means part of a
+			// window was lost. Either way the last ack is sent again, within a
+			// window only once so the sender does not resume for every block.
+			send = !outOfOrder || transfer.windowSize == 1
+			outOfOrder = true
+			received = 0
+			continue
+		}
+
+		outOfOrder = false
+
+		size := offset + int64(len(dataBlock.data))
+		if config.GetMaxUploadSize() > 0 && size > config.GetMaxUploadSize() {
+			return NewTftpError(DiskFullErrorCode, "file exceeds the upload limit of %d bytes", config.GetMaxUploadSize())
+		}
+
+		length := int64(len(dataBlock.data))
+		credited := min(credit, length)
+		credit = credit - credited
+
+		err = quotas.Reserve(conn.RemoteAddr(), length - credited)
+		if err != nil {
+			return err
+		}
+
+		reserved = reserved + length - credited
+
+		_, err = writer.Write(dataBlock.data)
+		if err != nil {
+			return FileError(err, writeRequest.filename)
+		}
+
+		dataBlockNumber = expectedBlockNumber
+		blockIndex = blockIndex+1
+		offset = size
+		received = received+1
+
+		AckToSlice(Ack{dataBlockNumber}, ackBuf)
+		reply = ackBuf
+
+		if dataBlock.IsFinal(transfer.blockSize) {
+			if netasciiWriter != nil {
+				err = netasciiWriter.Close()
+				if err != nil {
+					return FileError(err, writeRequest.filename)
+				}
+			}
+
+			upload := newUpload(conn, writeRequest, file, stored.count, digest)
+
+			err = hooks.RunValidators(upload, config.GetLogger())
+			if err != nil {
+				return err
+			}
+
+			committed = true
+			err = backend.Commit(file)
+			if err != nil {
+				return FileError(err, writeRequest.filename)
+			}
+
+			// a file shrunk by the upload returns the bytes it no longer holds
+			reserved = 0
+			quotas.Release(conn.RemoteAddr(), credit)
+			upload.StagedPath = ""
+
+			_, err = conn.WriteTo(reply)
+			if err != nil {
+				return err
+			}
+
+			hooks.RunObservers(upload, config.GetLogger())
+
+			break
+		}
+
+		if received == transfer.windowSize {
+			received = 0
+			send = true
+		}
+	}
+
+	// dally in case the final ack is lost and the final block is sent again,
+	// the file is complete so the transfer succeeds even if this fails.
+	for retry := 0; retry < config.GetRetries(); retry++ {
+		conn.SetReadTimeout(transfer.timeout)
+		numBytes, err := conn.ReadFrom(dataBlockBuf)
+		if err != nil {
+			break
+		}
+
+		dataBlock, err := ParseDataBlock(dataBlockBuf[:numBytes])
+		if err == nil && dataBlock.blockNumber == dataBlockNumber {
+			conn.WriteTo(reply)
+		}
+	}
+
+	config.GetLogger().Printf("transfer complete! %s %d blocks %d bytes", writeRequest.filename, blockIndex, offset)
+	return nil
+}
+
+//isRecentBlockNumber returns whether blockNumber is one of the last windowSize
+//blocks received, the last of them lastBlockNumber and received in all.
+func isRecentBlockNumber(blockNumber uint16, lastBlockNumber uint16, received uint64, windowSize int, rollover uint16) bool {
+	for i := 0; i < windowSize && uint64(i) < received; i++ {
+		if blockNumber == lastBlockNumber {
+			return true
+		}
+
+		lastBlockNumber = PreviousBlockNumber(lastBlockNumber, rollover)
+	}
+
+	return false
+}
+
@@ -0,0 +1,1491 @@
+package tftp
+
+import (
+	"context"
+	"bytes"
+	"io"
+	"testing"
+	"os"
+	"fmt"
+	"hash/crc32"
+	"net"
+	"io/ioutil"
+	"time"
+	"errors"
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+)
+
+type mockTimeoutError struct{}
+
+func (e mockTimeoutError) Error() string { return "i/o timeout" }
+func (e mockTimeoutError) Timeout() bool { return true }
+func (e mockTimeoutError) Temporary() bool { return true }
+
+//MockHandler receives the last packet written to a MockConnection and fills in
+//the packet returned by the next read.
+type MockHandler func(*testing.T, io.ReaderAt, []byte, []byte) int
+
+type MockConnection struct {
+	file io.ReaderAt
+	t *testing.T
+
+	input []byte
+	output []byte
+	inputLength int
+	outputLength int
+
+	err error
+	handle MockHandler
+
+	timeouts []time.Duration
+	writes int
+}
+
+func (m *MockConnection) WriteTo(bytes []byte) (numBytes int, err error) {
+	if m.err != nil {
+		return 0, m.err
+	}
+
+	copy(m.output, bytes)
+	m.outputLength = len(bytes)
+	m.writes = m.writes+1
+
+	return m.outputLength, nil
+}
+
+func (m *MockConnection) ReadFrom(bytes []byte) (numBytes int, err error) {
+	if m.err != nil {
+		return 0, m.err
+	}
+
+	// a handler returning a negative length simulates a read timeout
+	numBytes = m.handle(m.t, m.file, m.output[:m.outputLength], m.input)
+	if numBytes < 0 {
+		return 0, mockTimeoutError{}
+	}
+
+	// a packet larger than bytes is truncated like a udp read
+	return copy(bytes, m.input[:numBytes]), nil
+}
+
+func (m *MockConnection) SetReadTimeout(timeout time.Duration) {
+	m.timeouts = append(m.timeouts, timeout)
+}
+
+func (m *MockConnection) RemoteAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 6969}
+}
+
+func (m *MockConnection) Context() context.Context {
+	return context.Background()
+}
+
+func TestProcessReadRequest(t *testing.T) {
+	config := TftpConfig{fsroot:t.TempDir(), fstmp:t.TempDir(), ip:"127.0.0.1", port:8000, timeout:time.Second, retries:3}
+
+	ioRequest := IORequest{isWrite:false, filename:"test.txt", mode:"octet"}
+
+	fname := filepath.Join(config.GetFSRoot(), ioRequest.filename)
+	CreateTestFile(fname, 512*5+256)
+
+	file, err := os.Open(fname)
+	if err != nil {
+		t.Error(err)
+	}
+
+	connection := &MockConnection{file:file, t:t, input:make([]byte, 520), output:make([]byte, 520), handle:ReadHandler}
+
+	err = ProcessReadRequest(connection, ioRequest, config)
+	if err != nil {
+		t.Error(err)
+	}
+
+	file.Close()
+}
+
+func ReadHandler(t *testing.T, f io.ReaderAt, dataBlockBytes []byte, ackBytes []byte) int {
+	return BlockSizeReadHandler(512)(t, f, dataBlockBytes, ackBytes)
+}
+
+//BlockSizeReadHandler returns a read handler for a transfer using blockSize byte
+//data blocks.
+func BlockSizeReadHandler(blockSize int) MockHandler {
+	return func(t *testing.T, f io.ReaderAt, dataBlockBytes []byte, ackBytes []byte) int {
+
+		dataBlock, err := ParseDataBlock(dataBlockBytes)
+		if err != nil {
+			t.Error(err)
+		}
+
+		buf := make([]byte, blockSize)
+		numBytes, err:= f.ReadAt(buf, int64(dataBlock.blockNumber-1)*int64(blockSize))
+		if err != nil && err != io.EOF {
+			t.Error(err)
+		}
+
+		if bytes.Compare(dataBlock.data, buf[:numBytes]) != 0 {
+			t.Error("file bytes doesn't match the data block returned")
+		}
+
+		ack := Ack{dataBlock.blockNumber}
+		AckToSlice(ack, ackBytes)
+
+		return 4
+	}
+}
+
+//DropHandler wraps a handler so that the first drops reads time out.
+func DropHandler(drops int, handle MockHandler) MockHandler {
+	return func(t *testing.T, f io.ReaderAt, output []byte, input []byte) int {
+		if drops > 0 {
+			drops = drops-1
+			return -1
+		}
+
+		return handle(t, f, output, input)
+	}
+}
+
+func TestProcessReadRequestRetransmit(t *testing.T) {
+	config := TftpConfig{fsroot:t.TempDir(), fstmp:t.TempDir(), ip:"127.0.0.1", port:8000, timeout:time.Second, retries:3}
+
+	ioRequest := IORequest{isWrite:false, filename:"test.txt", mode:"octet"}
+
+	fname := filepath.Join(config.GetFSRoot(), ioRequest.filename)
+	CreateTestFile(fname, 512*2+10)
+
+	file, err := os.Open(fname)
+	if err != nil {
+		t.Error(err)
+	}
+	defer file.Close()
+
+	connection := &MockConnection{file:file, t:t, input:make([]byte, 520), output:make([]byte, 520), handle:DropHandler(3, ReadHandler)}
+
+	err = ProcessReadRequest(connection, ioRequest, config)
+	if err != nil {
+		t.Error(err)
+	}
+
+	expected := []time.Duration{time.Second, 2*time.Second, 4*time.Second, 8*time.Second, time.Second, time.Second}
+	if fmt.Sprint(connection.timeouts) != fmt.Sprint(expected) {
+		t.Error(fmt.Sprintf("expected read timeouts %v got %v", expected, connection.timeouts))
+	}
+}
+
+func TestProcessReadRequestRetransmitMaxTimeout(t *testing.T) {
+	config := TftpConfig{fsroot:t.TempDir(), fstmp:t.TempDir(), ip:"127.0.0.1", port:8000, timeout:100*time.Second, retries:3}
+
+	ioRequest := IORequest{isWrite:false, filename:"test.txt", mode:"octet"}
+
+	fname := filepath.Join(config.GetFSRoot(), ioRequest.filename)
+	CreateTestFile(fname, 10)
+
+	file, err := os.Open(fname)
+	if err != nil {
+		t.Error(err)
+	}
+	defer file.Close()
+
+	connection := &MockConnection{file:file, t:t, input:make([]byte, 520), output:make([]byte, 520), handle:DropHandler(3, ReadHandler)}
+
+	err = ProcessReadRequest(connection, ioRequest, config)
+	if err != nil {
+		t.Error(err)
+	}
+
+	expected := []time.Duration{100*time.Second, 200*time.Second, 255*time.Second, 255*time.Second}
+	if fmt.Sprint(connection.timeouts) != fmt.Sprint(expected) {
+		t.Error(fmt.Sprintf("expected read timeouts %v got %v", expected, connection.timeouts))
+	}
+}
+
+func TestProcessReadRequestNegotiatedTimeout(t *testing.T) {
+	config := TftpConfig{fsroot:t.TempDir(), fstmp:t.TempDir(), ip:"127.0.0.1", port:8000, timeout:time.Second, retries:3}
+
+	ioRequest := IORequest{isWrite:false, filename:"test.txt", mode:"octet"}
+	ioRequest.options.Set("timeout", "5")
+
+	fname := filepath.Join(config.GetFSRoot(), ioRequest.filename)
+	CreateTestFile(fname, 10)
+
+	file, err := os.Open(fname)
+	if err != nil {
+		t.Error(err)
+	}
+	defer file.Close()
+
+	connection := &MockConnection{file:file, t:t, input:make([]byte, 520), output:make([]byte, 520), handle:OAckHandler([]byte{0,4,0,0}, DropHandler(2, ReadHandler))}
+
+	err = ProcessReadRequest(connection, ioRequest, config)
+	if err != nil {
+		t.Error(err)
+	}
+
+	expected := []time.Duration{5*time.Second, 5*time.Second, 5*time.Second, 5*time.Second}
+	if fmt.Sprint(connection.timeouts) != fmt.Sprint(expected) {
+		t.Error(fmt.Sprintf("expected read timeouts %v got %v", expected, connection.timeouts))
+	}
+}
+
+func TestProcessReadRequestRetriesExhausted(t *testing.T) {
+	config := TftpConfig{fsroot:t.TempDir(), fstmp:t.TempDir(), ip:"127.0.0.1", port:8000, timeout:time.Second, retries:3}
+
+	ioRequest := IORequest{isWrite:false, filename:"test.txt", mode:"octet"}
+
+	fname := filepath.Join(config.GetFSRoot(), ioRequest.filename)
+	CreateTestFile(fname, 512*2+10)
+
+	file, err := os.Open(fname)
+	if err != nil {
+		t.Error(err)
+	}
+	defer file.Close()
+
+	connection := &MockConnection{file:file, t:t, input:make([]byte, 520), output:make([]byte, 520), handle:DropHandler(4, ReadHandler)}
+
+	err = ProcessReadRequest(connection, ioRequest, config)
+	if _, ok := err.(TftpError); !ok {
+		t.Error(fmt.Sprintf("expected a tftp error after exhausting retries got %v", err))
+	}
+
+	if len(connection.timeouts) != 4 {
+		t.Error(fmt.Sprintf("expected 4 reads got %d", len(connection.timeouts)))
+	}
+}
+
+func TestProcessReadRequestStaleAcks(t *testing.T) {
+	config := TftpConfig{fsroot:t.TempDir(), fstmp:t.TempDir(), ip:"127.0.0.1", port:8000, timeout:10*time.Millisecond, retries:3}
+
+	ioRequest := IORequest{isWrite:false, filename:"test.txt", mode:"octet"}
+
+	fname := filepath.Join(config.GetFSRoot(), ioRequest.filename)
+	CreateTestFile(fname, 512*2+10)
+
+	file, err := os.Open(fname)
+	if err != nil {
+		t.Error(err)
+	}
+	defer file.Close()
+
+	// the client repeats the ack before block 1 faster than the server times out
+	staleAck := func(t *testing.T, f io.ReaderAt, output []byte, input []byte) int {
+		return AckToSlice(Ack{0}, input)
+	}
+
+	connection := &MockConnection{file:file, t:t, input:make([]byte, 520), output:make([]byte, 520), handle:staleAck}
+
+	err = ProcessReadRequest(connection, ioRequest, config)
+	if _, ok := err.(TftpError); !ok {
+		t.Error(fmt.Sprintf("expected a tftp error after exhausting retries got %v", err))
+	}
+
+	if connection.writes != 4 {
+		t.Error(fmt.Sprintf("expected block 1 to be sent once and re-sent 3 times got %d writes", connection.writes))
+	}
+}
+
+func TestProcessWriteRequestRetransmit(t *testing.T) {
+	config := TftpConfig{fsroot:t.TempDir(), fstmp:t.TempDir(), ip:"127.0.0.1", port:8000, timeout:time.Second, retries:3}
+
+	ioRequest := IORequest{isWrite:true, filename:"test.txt", mode:"octet"}
+
+	fname := filepath.Join(config.GetFSTmp(), "test-expected.txt")
+	CreateTestFile(fname, 512*2+10)
+
+	file, err := os.Open(fname)
+	if err != nil {
+		t.Error(err)
+	}
+	defer file.Close()
+
+	connection := &MockConnection{file:file, t:t, input:make([]byte, 520), output:make([]byte, 520), handle:DropHandler(2, WriteHandler)}
+
+	err = ProcessWriteRequest(connection, ioRequest, config)
+	if err != nil {
+		t.Error(err)
+	}
+
+	hashExpected, _ := GetHash(fname)
+	hashActual, _ := GetHash(filepath.Join(config.GetFSRoot(), "test.txt"))
+
+	if hashExpected != hashActual {
+		t.Error("files mismatched while writing")
+	}
+}
+
+//OAckHandler wraps a handler so that an oack is answered with reply before the
+//transfer continues with the wrapped handler.
+func OAckHandler(reply []byte, handle MockHandler) MockHandler {
+	return func(t *testing.T, f io.ReaderAt, output []byte, input []byte) int {
+		opcode, _ := ParseOpcode(output)
+		if opcode == oackOpcode {
+			return copy(input, reply)
+		}
+
+		return handle(t, f, output, input)
+	}
+}
+
+func TestProcessReadRequestOAck(t *testing.T) {
+	defer RegisterTestOption("x-test")()
+
+	config := TftpConfig{fsroot:t.TempDir(), fstmp:t.TempDir(), ip:"127.0.0.1", port:8000, timeout:time.Second, retries:3}
+
+	ioRequest := IORequest{isWrite:false, filename:"test.txt", mode:"octet"}
+	ioRequest.options.Set("x-test", "1")
+
+	fname := filepath.Join(config.GetFSRoot(), ioRequest.filename)
+	CreateTestFile(fname, 512*2+10)
+
+	file, err := os.Open(fname)
+	if err != nil {
+		t.Error(err)
+	}
+	defer file.Close()
+
+	connection := &MockConnection{file:file, t:t, input:make([]byte, 520), output:make([]byte, 520), handle:OAckHandler([]byte{0,4,0,0}, ReadHandler)}
+
+	err = ProcessReadRequest(connection, ioRequest, config)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestProcessReadRequestOAckRejected(t *testing.T) {
+	defer RegisterTestOption("x-test")()
+
+	config := TftpConfig{fsroot:t.TempDir(), fstmp:t.TempDir(), ip:"127.0.0.1", port:8000, timeout:time.Second, retries:3}
+
+	ioRequest := IORequest{isWrite:false, filename:"test.txt", mode:"octet"}
+	ioRequest.options.Set("x-test", "1")
+
+	fname := filepath.Join(config.GetFSRoot(), ioRequest.filename)
+	CreateTestFile(fname, 10)
+
+	file, err := os.Open(fname)
+	if err != nil {
+		t.Error(err)
+	}
+	defer file.Close()
+
+	rejection := []byte{0,5,0,8,'n','o',0}
+	connection := &MockConnection{file:file, t:t, input:make([]byte, 520), output:make([]byte, 520), handle:OAckHandler(rejection, ReadHandler)}
+
+	err = ProcessReadRequest(connection, ioRequest, config)
+	if _, ok := err.(RemoteError); !ok {
+		t.Error(fmt.Sprintf("expected a remote error got %v", err))
+	}
+}
+
+func TestProcessWriteRequestOAck(t *testing.T) {
+	defer RegisterTestOption("x-test")()
+
+	config := TftpConfig{fsroot:t.TempDir(), fstmp:t.TempDir(), ip:"127.0.0.1", port:8000, timeout:time.Second, retries:3}
+
+	ioRequest := IORequest{isWrite:true, filename:"test.txt", mode:"octet"}
+	ioRequest.options.Set("x-test", "1")
+
+	fname := filepath.Join(config.GetFSTmp(), "test-expected.txt")
+	CreateTestFile(fname, 512*2+10)
+
+	file, err := os.Open(fname)
+	if err != nil {
+		t.Error(err)
+	}
+	defer file.Close()
+
+	firstBlock := make([]byte, 516)
+	numBytes := DataBlockToSlice(DataBlock{1, make([]byte, 512)}, firstBlock)
+	file.ReadAt(firstBlock[4:numBytes], 0)
+
+	connection := &MockConnection{file:file, t:t, input:make([]byte, 520), output:make([]byte, 520), handle:OAckHandler(firstBlock, WriteHandler)}
+
+	err = ProcessWriteRequest(connection, ioRequest, config)
+	if err != nil {
+		t.Error(err)
+	}
+
+	hashExpected, _ := GetHash(fname)
+	hashActual, _ := GetHash(filepath.Join(config.GetFSRoot(), "test.txt"))
+
+	if hashExpected != hashActual {
+		t.Error("files mismatched while writing")
+	}
+}
+
+func TestProcessReadRequestBlockSize(t *testing.T) {
+	config := TftpConfig{fsroot:t.TempDir(), fstmp:t.TempDir(), ip:"127.0.0.1", port:8000, timeout:time.Second, retries:3}
+
+	ioRequest := IORequest{isWrite:false, filename:"test.txt", mode:"octet"}
+	ioRequest.options.Set("blksize", "1024")
+
+	fname := filepath.Join(config.GetFSRoot(), ioRequest.filename)
+	CreateTestFile(fname, 1024*3)
+
+	file, err := os.Open(fname)
+	if err != nil {
+		t.Error(err)
+	}
+	defer file.Close()
+
+	blocks := 0
+	handle := OAckHandler([]byte{0,4,0,0}, BlockSizeReadHandler(1024))
+	connection := &MockConnection{file:file, t:t, input:make([]byte, 1028), output:make([]byte, 1028)}
+	connection.handle = func(t *testing.T, f io.ReaderAt, output []byte, input []byte) int {
+		if opcode, _ := ParseOpcode(output); opcode == dataBlockOpcode {
+			blocks = blocks+1
+		}
+
+		return handle(t, f, output, input)
+	}
+
+	err = ProcessReadRequest(connection, ioRequest, config)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// a file that is a multiple of the block size ends with an empty block
+	if blocks != 4 {
+		t.Error(fmt.Sprintf("expected 4 data blocks got %d", blocks))
+	}
+}
+
+func TestProcessWriteRequestBlockSize(t *testing.T) {
+	config := TftpConfig{fsroot:t.TempDir(), fstmp:t.TempDir(), ip:"127.0.0.1", port:8000, timeout:time.Second, retries:3}
+
+	ioRequest := IORequest{isWrite:true, filename:"test.txt", mode:"octet"}
+	ioRequest.options.Set("blksize", "1024")
+
+	fname := filepath.Join(config.GetFSTmp(), "test-expected.txt")
+	CreateTestFile(fname, 1024*3+10)
+
+	file, err := os.Open(fname)
+	if err != nil {
+		t.Error(err)
+	}
+	defer file.Close()
+
+	handle := BlockSizeWriteHandler(1024)
+	connection := &MockConnection{file:file, t:t, input:make([]byte, 1028), output:make([]byte, 1028)}
+	connection.handle = func(t *testing.T, f io.ReaderAt, output []byte, input []byte) int {
+		if opcode, _ := ParseOpcode(output); opcode == oackOpcode {
+			return handle(t, f, []byte{0,4,0,0}, input)
+		}
+
+		return handle(t, f, output, input)
+	}
+
+	err = ProcessWriteRequest(connection, ioRequest, config)
+	if err != nil {
+		t.Error(err)
+	}
+
+	hashExpected, _ := GetHash(fname)
+	hashActual, _ := GetHash(filepath.Join(config.GetFSRoot(), "test.txt"))
+
+	if hashExpected != hashActual {
+		t.Error("files mismatched while writing")
+	}
+}
+
+func TestProcessReadRequestWindowSize(t *testing.T) {
+	config := TftpConfig{fsroot:t.TempDir(), fstmp:t.TempDir(), ip:"127.0.0.1", port:8000, timeout:time.Second, retries:3}
+
+	ioRequest := IORequest{isWrite:false, filename:"test.txt", mode:"octet"}
+	ioRequest.options.Set("windowsize", "4")
+
+	fname := filepath.Join(config.GetFSRoot(), ioRequest.filename)
+	CreateTestFile(fname, 512*9+10)
+
+	file, err := os.Open(fname)
+	if err != nil {
+		t.Error(err)
+	}
+	defer file.Close()
+
+	// the ack for the second window only covers its first two blocks, as if
+	// the last two had been lost.
+	lost := true
+	handle := OAckHandler([]byte{0,4,0,0}, ReadHandler)
+	connection := &MockConnection{file:file, t:t, input:make([]byte, 520), output:make([]byte, 520)}
+	connection.handle = func(t *testing.T, f io.ReaderAt, output []byte, input []byte) int {
+		dataBlock, _ := ParseDataBlock(output)
+		if lost && dataBlock.blockNumber == 8 {
+			lost = false
+			return AckToSlice(Ack{6}, input)
+		}
+
+		return handle(t, f, output, input)
+	}
+
+	err = ProcessReadRequest(connection, ioRequest, config)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// oack, blocks 1-4, blocks 5-8, blocks 7-10
+	if connection.writes != 13 {
+		t.Error(fmt.Sprintf("expected 13 packets to be sent got %d", connection.writes))
+	}
+}
+
+//WindowWriteHandler returns a write handler that sends data blocks until a new
+//ack arrives, skip is a block that is left out the first time it is due.
+func WindowWriteHandler(skip uint16) MockHandler {
+	next := uint16(1)
+	lastAck := uint16(0)
+
+	return func(t *testing.T, f io.ReaderAt, ackBytes []byte, dataBlockBytes []byte) int {
+		ack, err := ParseAck(ackBytes)
+		if err == nil && ack.blockNumber != lastAck {
+			lastAck = ack.blockNumber
+			next = ack.blockNumber+1
+		}
+
+		if next == skip {
+			skip = 0
+			next = next+1
+		}
+
+		if int64(next-1)*512 > FileSize(f) {
+			return -1
+		}
+
+		buf := make([]byte, 512)
+		numBytes, err := f.ReadAt(buf, int64(next-1)*512)
+		if err != nil && err != io.EOF {
+			t.Error(err)
+		}
+
+		numBytes = DataBlockToSlice(DataBlock{next, buf[:numBytes]}, dataBlockBytes)
+		next = next+1
+
+		return numBytes
+	}
+}
+
+func TestProcessWriteRequestWindowSize(t *testing.T) {
+	config := TftpConfig{fsroot:t.TempDir(), fstmp:t.TempDir(), ip:"127.0.0.1", port:8000, timeout:time.Second, retries:3}
+
+	ioRequest := IORequest{isWrite:true, filename:"test.txt", mode:"octet"}
+	ioRequest.options.Set("windowsize", "4")
+
+	fname := filepath.Join(config.GetFSTmp(), "test-expected.txt")
+	CreateTestFile(fname, 512*9+10)
+
+	file, err := os.Open(fname)
+	if err != nil {
+		t.Error(err)
+	}
+	defer file.Close()
+
+	connection := &MockConnection{file:file, t:t, input:make([]byte, 520), output:make([]byte, 520), handle:WindowWriteHandler(6)}
+
+	err = ProcessWriteRequest(connection, ioRequest, config)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// oack, ack 4, ack 5 after block 6 was skipped, ack 9, ack 10
+	if connection.writes != 5 {
+		t.Error(fmt.Sprintf("expected 5 packets to be sent got %d", connection.writes))
+	}
+
+	hashExpected, _ := GetHash(fname)
+	hashActual, _ := GetHash(filepath.Join(config.GetFSRoot(), "test.txt"))
+
+	if hashExpected != hashActual {
+		t.Error("files mismatched while writing")
+	}
+}
+
+//RolloverReadHandler returns a read handler that checks data blocks against the
+//file by their absolute index and expects block numbers to roll over to rollover.
+func RolloverReadHandler(blockSize int, rollover uint16) MockHandler {
+	blockIndex := int64(0)
+	expected := uint16(1)
+
+	return func(t *testing.T, f io.ReaderAt, dataBlockBytes []byte, ackBytes []byte) int {
+		if opcode, _ := ParseOpcode(dataBlockBytes); opcode == oackOpcode {
+			return AckToSlice(Ack{0}, ackBytes)
+		}
+
+		dataBlock, err := ParseDataBlock(dataBlockBytes)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if dataBlock.blockNumber != expected {
+			t.Fatal(fmt.Sprintf("expected block number %d got %d at block index %d", expected, dataBlock.blockNumber, blockIndex))
+		}
+
+		buf := make([]byte, blockSize)
+		numBytes, err := f.ReadAt(buf, blockIndex*int64(blockSize))
+		if err != nil && err != io.EOF {
+			t.Error(err)
+		}
+
+		if bytes.Compare(dataBlock.data, buf[:numBytes]) != 0 {
+			t.Fatal(fmt.Sprintf("file bytes doesn't match the data block returned at block index %d", blockIndex))
+		}
+
+		blockIndex = blockIndex+1
+		expected = NextBlockNumber(expected, rollover)
+
+		return AckToSlice(Ack{dataBlock.blockNumber}, ackBytes)
+	}
+}
+
+//RolloverWriteHandler returns a write handler that sends the file by absolute
+//block index and rolls block numbers over to rollover.
+func RolloverWriteHandler(blockSize int, rollover uint16) MockHandler {
+	blockIndex := int64(0)
+	blockNumber := uint16(0)
+
+	return func(t *testing.T, f io.ReaderAt, ackBytes []byte, dataBlockBytes []byte) int {
+		if opcode, _ := ParseOpcode(ackBytes); opcode != oackOpcode {
+			ack, err := ParseAck(ackBytes)
+			if err != nil || ack.blockNumber != blockNumber {
+				t.Fatal(fmt.Sprintf("expected ack %d got %d at block index %d", blockNumber, ack.blockNumber, blockIndex))
+			}
+		}
+
+		if blockIndex*int64(blockSize) > FileSize(f) {
+			return -1
+		}
+
+		buf := make([]byte, blockSize)
+		numBytes, err := f.ReadAt(buf, blockIndex*int64(blockSize))
+		if err != nil && err != io.EOF {
+			t.Error(err)
+		}
+
+		blockIndex = blockIndex+1
+		blockNumber = NextBlockNumber(blockNumber, rollover)
+
+		return DataBlockToSlice(DataBlock{blockNumber, buf[:numBytes]}, dataBlockBytes)
+	}
+}
+
+func TestProcessReadRequestRollover(t *testing.T) {
+	for _, rollover := range []uint16{0, 1} {
+		config := TftpConfig{fsroot:t.TempDir(), fstmp:t.TempDir(), ip:"127.0.0.1", port:8000, timeout:time.Second, retries:3, blockRollover:rollover}
+
+		ioRequest := IORequest{isWrite:false, filename:"test.txt", mode:"octet"}
+		ioRequest.options.Set("blksize", "8")
+
+		fname := filepath.Join(config.GetFSRoot(), ioRequest.filename)
+		CreateTestFile(fname, 8*65540+3)
+
+		file, err := os.Open(fname)
+		if err != nil {
+			t.Error(err)
+		}
+
+		connection := &MockConnection{file:file, t:t, input:make([]byte, 520), output:make([]byte, 520), handle:RolloverReadHandler(8, rollover)}
+
+		err = ProcessReadRequest(connection, ioRequest, config)
+		if err != nil {
+			t.Error(err)
+		}
+
+		file.Close()
+	}
+}
+
+func TestProcessWriteRequestRollover(t *testing.T) {
+	for _, rollover := range []uint16{0, 1} {
+		config := TftpConfig{fsroot:t.TempDir(), fstmp:t.TempDir(), ip:"127.0.0.1", port:8000, timeout:time.Second, retries:3, blockRollover:rollover}
+
+		ioRequest := IORequest{isWrite:true, filename:"test.txt", mode:"octet"}
+		ioRequest.options.Set("blksize", "8")
+
+		fname := filepath.Join(config.GetFSTmp(), "test-expected.txt")
+		CreateTestFile(fname, 8*65540+3)
+
+		file, err := os.Open(fname)
+		if err != nil {
+			t.Error(err)
+		}
+
+		connection := &MockConnection{file:file, t:t, input:make([]byte, 520), output:make([]byte, 520), handle:RolloverWriteHandler(8, rollover)}
+
+		err = ProcessWriteRequest(connection, ioRequest, config)
+		if err != nil {
+			t.Error(err)
+		}
+
+		file.Close()
+
+		hashExpected, _ := GetHash(fname)
+		hashActual, _ := GetHash(filepath.Join(config.GetFSRoot(), "test.txt"))
+
+		if hashExpected != hashActual {
+			t.Error(fmt.Sprintf("files mismatched while writing with rollover %d", rollover))
+		}
+	}
+}
+
+func TestProcessReadRequestDuplicateAck(t *testing.T) {
+	config := TftpConfig{fsroot:t.TempDir(), fstmp:t.TempDir(), ip:"127.0.0.1", port:8000, timeout:time.Second, retries:3}
+
+	ioRequest := IORequest{isWrite:false, filename:"test.txt", mode:"octet"}
+
+	fname := filepath.Join(config.GetFSRoot(), ioRequest.filename)
+	CreateTestFile(fname, 512*3+10)
+
+	file, err := os.Open(fname)
+	if err != nil {
+		t.Error(err)
+	}
+	defer file.Close()
+
+	// block 2 is answered with a delayed duplicate of ack 1 before its own ack
+	duplicate := true
+	connection := &MockConnection{file:file, t:t, input:make([]byte, 520), output:make([]byte, 520)}
+	connection.handle = func(t *testing.T, f io.ReaderAt, output []byte, input []byte) int {
+		dataBlock, _ := ParseDataBlock(output)
+		if duplicate && dataBlock.blockNumber == 2 {
+			duplicate = false
+			return AckToSlice(Ack{1}, input)
+		}
+
+		return ReadHandler(t, f, output, input)
+	}
+
+	err = ProcessReadRequest(connection, ioRequest, config)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// the duplicate ack must not cause block 2 to be sent again
+	if connection.writes != 4 {
+		t.Error(fmt.Sprintf("expected 4 data blocks to be sent got %d", connection.writes))
+	}
+}
+
+func TestProcessWriteRequestDuplicateData(t *testing.T) {
+	config := TftpConfig{fsroot:t.TempDir(), fstmp:t.TempDir(), ip:"127.0.0.1", port:8000, timeout:time.Second, retries:3}
+
+	ioRequest := IORequest{isWrite:true, filename:"test.txt", mode:"octet"}
+
+	fname := filepath.Join(config.GetFSTmp(), "test-expected.txt")
+	CreateTestFile(fname, 512*3+10)
+
+	file, err := os.Open(fname)
+	if err != nil {
+		t.Error(err)
+	}
+	defer file.Close()
+
+	// block 2 and the final block 4 are sent twice as if their acks were lost
+	duplicates := map[uint16]bool{2: true, 4: true}
+	connection := &MockConnection{file:file, t:t, input:make([]byte, 520), output:make([]byte, 520)}
+	connection.handle = func(t *testing.T, f io.ReaderAt, output []byte, input []byte) int {
+		ack, _ := ParseAck(output)
+		if duplicates[ack.blockNumber] {
+			delete(duplicates, ack.blockNumber)
+			return WriteHandler(t, f, []byte{0,4,0,byte(ack.blockNumber-1)}, input)
+		}
+
+		return WriteHandler(t, f, output, input)
+	}
+
+	err = ProcessWriteRequest(connection, ioRequest, config)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// acks 0-4 and the duplicates of ack 2 and ack 4
+	if connection.writes != 7 {
+		t.Error(fmt.Sprintf("expected 7 acks to be sent got %d", connection.writes))
+	}
+
+	hashExpected, _ := GetHash(fname)
+	hashActual, _ := GetHash(filepath.Join(config.GetFSRoot(), "test.txt"))
+
+	if hashExpected != hashActual {
+		t.Error("files mismatched while writing duplicate blocks")
+	}
+}
+
+func CreateTestFile(filename string, length int) {
+	file, err := os.Create(filename)
+	defer file.Close()
+
+	if err != nil {
+		panic(err)
+	}
+
+	file.Write(PatternData(length))
+}
+
+//PatternData returns length bytes of a pattern that does not repeat every block.
+func PatternData(length int) []byte {
+	buf := make([]byte, length)
+	for i:=0; i<len(buf); i=i+1 {
+		buf[i] = byte(i) ^ byte(i>>8) ^ byte(i>>16)
+	}
+
+	return buf
+}
+
+func TestProcessReadRequestFileNotFound(t *testing.T) {
+	config := TftpConfig{fsroot:t.TempDir(), fstmp:t.TempDir(), ip:"127.0.0.1", port:8000, timeout:time.Second, retries:3}
+
+	ioRequest := IORequest{isWrite:false, filename:"missing.txt", mode:"octet"}
+
+	connection := &MockConnection{t:t, input:make([]byte, 520), output:make([]byte, 520), handle:ReadHandler}
+
+	err := ProcessReadRequest(connection, ioRequest, config)
+	if err == nil {
+		t.Fatal("expected an error reading a missing file")
+	}
+
+	SendError(connection, err)
+
+	tftpErr, err := ParseTftpErrorSlice(connection.output[:connection.outputLength])
+	if err != nil {
+		t.Error(err)
+	}
+
+	if tftpErr.errorCode != FileNotFoundErrorCode || tftpErr.errMsg != "file not found: missing.txt" {
+		t.Error(fmt.Sprintf("expected file not found error got %d %q", tftpErr.errorCode, tftpErr.errMsg))
+	}
+}
+
+func TestProcessReadRequestPathTraversal(t *testing.T) {
+	config := TftpConfig{fsroot:t.TempDir(), fstmp:t.TempDir(), ip:"127.0.0.1", port:8000, timeout:time.Second, retries:3}
+
+	ioRequest := IORequest{isWrite:false, filename:"../../etc/passwd", mode:"octet"}
+
+	connection := &MockConnection{t:t, input:make([]byte, 520), output:make([]byte, 520), handle:ReadHandler}
+
+	err := ProcessReadRequest(connection, ioRequest, config)
+	if tftpErr, ok := err.(TftpError); !ok || tftpErr.errorCode != AccessViolationErrorCode {
+		t.Error(fmt.Sprintf("expected an access violation got %v", err))
+	}
+
+	if connection.writes != 0 {
+		t.Error("data was sent for a filename outside the root")
+	}
+}
+
+func TestProcessWriteRequest(t *testing.T) {
+	config := TftpConfig{fsroot:t.TempDir(), fstmp:t.TempDir(), ip:"127.0.0.1", port:8000, timeout:time.Second, retries:3}
+
+	ioRequest := IORequest{isWrite:true, filename:"test.txt", mode:"octet"}
+
+	fname := filepath.Join(config.GetFSTmp(), "test-expected.txt")
+	CreateTestFile(fname, 512*5+256)
+
+	file, err := os.Open(fname)
+	if err != nil {
+		t.Error(err)
+	}
+
+	connection := &MockConnection{file:file, t:t, input:make([]byte, 520), output:make([]byte, 520), handle:WriteHandler}
+
+	err = ProcessWriteRequest(connection, ioRequest, config)
+	if err != nil {
+		t.Error(err)
+	}
+
+	file.Close()
+
+	hashExpected, _ := GetHash(fname)
+	hashActual, _ := GetHash(filepath.Join(config.GetFSRoot(), "test.txt"))
+
+	if hashExpected != hashActual {
+		t.Error("files mismatched while writing")
+	}
+}
+
+func TestProcessWriteRequestOversizedBlock(t *testing.T) {
+	config := TftpConfig{fsroot:t.TempDir(), fstmp:t.TempDir(), ip:"127.0.0.1", port:8000, timeout:time.Second, retries:3}
+
+	ioRequest := IORequest{isWrite:true, filename:"test.txt", mode:"octet"}
+
+	// the client sends 600 byte blocks on a transfer that negotiated 512
+	connection := &MockConnection{file:bytes.NewReader(PatternData(600*2)), t:t, input:make([]byte, 700), output:make([]byte, 700), handle:BlockSizeWriteHandler(600)}
+
+	err := ProcessWriteRequest(connection, ioRequest, config)
+	if tftpErr, ok := err.(TftpError); !ok || tftpErr.GetCode() != IllegalOperationErrorCode {
+		t.Error(fmt.Sprintf("expected an illegal operation for an oversized block got %v", err))
+	}
+
+	if _, err := os.Stat(filepath.Join(config.GetFSRoot(), "test.txt")); !os.IsNotExist(err) {
+		t.Error(fmt.Sprintf("expected the upload to be discarded got %v", err))
+	}
+}
+
+//netasciiBoundaryText returns text whose LF is the 512th byte, so its CR LF is
+//split between the first two blocks, followed by size bytes and a bare CR, and
+//the same text encoded as netascii.
+func netasciiBoundaryText(size int) ([]byte, []byte) {
+	text := append(bytes.Repeat([]byte("a"), 511), '\n')
+	text = append(text, bytes.Repeat([]byte("b"), size)...)
+	text = append(text, '\r')
+
+	encoded := append(bytes.Repeat([]byte("a"), 511), '\r', '\n')
+	encoded = append(encoded, bytes.Repeat([]byte("b"), size)...)
+	encoded = append(encoded, '\r', 0)
+
+	return text, encoded
+}
+
+func TestProcessReadRequestNetascii(t *testing.T) {
+	// the encoded text ends in a short block, then in a full block followed by
+	// an empty one
+	for _, size := range []int{100, 509} {
+		config := TftpConfig{fsroot:t.TempDir(), fstmp:t.TempDir(), ip:"127.0.0.1", port:8000, timeout:time.Second, retries:3}
+
+		ioRequest := IORequest{isWrite:false, filename:"test.txt", mode:"netascii"}
+
+		text, expected := netasciiBoundaryText(size)
+		os.WriteFile(filepath.Join(config.GetFSRoot(), ioRequest.filename), text, 0666)
+
+		received := bytes.Buffer{}
+		blocks := 0
+		collect := func(t *testing.T, f io.ReaderAt, dataBlockBytes []byte, ackBytes []byte) int {
+			dataBlock, err := ParseDataBlock(dataBlockBytes)
+			if err != nil {
+				t.Error(err)
+			}
+
+			if int(dataBlock.blockNumber) != blocks+1 {
+				t.Error(fmt.Sprintf("expected datablock %d got %d", blocks+1, dataBlock.blockNumber))
+			}
+
+			if !dataBlock.IsFinal(512) && len(dataBlock.data) != 512 {
+				t.Error(fmt.Sprintf("expected a full datablock got %d bytes", len(dataBlock.data)))
+			}
+
+			blocks = blocks+1
+			received.Write(dataBlock.data)
+
+			return AckToSlice(Ack{dataBlock.blockNumber}, ackBytes)
+		}
+
+		connection := &MockConnection{t:t, input:make([]byte, 520), output:make([]byte, 520), handle:collect}
+
+		err := ProcessReadRequest(connection, ioRequest, config)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if blocks != len(expected)/512+1 {
+			t.Error(fmt.Sprintf("%d bytes: expected %d datablocks got %d", len(expected), len(expected)/512+1, blocks))
+		}
+
+		if bytes.Compare(received.Bytes(), expected) != 0 {
+			t.Error(fmt.Sprintf("%d bytes: unexpected netascii sent %q", len(expected), received.Bytes()))
+		}
+	}
+}
+
+func TestProcessWriteRequestNetascii(t *testing.T) {
+	for _, size := range []int{100, 509} {
+		config := TftpConfig{fsroot:t.TempDir(), fstmp:t.TempDir(), ip:"127.0.0.1", port:8000, timeout:time.Second, retries:3}
+
+		ioRequest := IORequest{isWrite:true, filename:"test.txt", mode:"netascii"}
+
+		expected, encoded := netasciiBoundaryText(size)
+
+		connection := &MockConnection{file:bytes.NewReader(encoded), t:t, input:make([]byte, 520), output:make([]byte, 520), handle:WriteHandler}
+
+		err := ProcessWriteRequest(connection, ioRequest, config)
+		if err != nil {
+			t.Error(err)
+		}
+
+		stored, err := os.ReadFile(filepath.Join(config.GetFSRoot(), ioRequest.filename))
+		if err != nil {
+			t.Error(err)
+		}
+
+		if bytes.Compare(stored, expected) != 0 {
+			t.Error(fmt.Sprintf("%d bytes: unexpected file stored %q", len(encoded), stored))
+		}
+	}
+}
+
+func TestProcessReadRequestMemoryBackend(t *testing.T) {
+	t.Parallel()
+
+	backend := NewMemoryBackend()
+	config := TftpConfig{timeout:time.Second, retries:3, backend:backend}
+
+	data := PatternData(512*5+256)
+	backend.Store("pxelinux.cfg/default", data)
+
+	ioRequest := IORequest{isWrite:false, filename:"pxelinux.cfg/default", mode:"octet"}
+
+	connection := &MockConnection{file:bytes.NewReader(data), t:t, input:make([]byte, 520), output:make([]byte, 520), handle:ReadHandler}
+
+	err := ProcessReadRequest(connection, ioRequest, config)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if connection.writes != 6 {
+		t.Error(fmt.Sprintf("expected 6 data blocks to be sent got %d", connection.writes))
+	}
+}
+
+func TestProcessWriteRequestMemoryBackend(t *testing.T) {
+	t.Parallel()
+
+	backend := NewMemoryBackend()
+	config := TftpConfig{timeout:time.Second, retries:3, backend:backend}
+
+	data := PatternData(512*5+256)
+
+	ioRequest := IORequest{isWrite:true, filename:"upload.bin", mode:"octet"}
+
+	connection := &MockConnection{file:bytes.NewReader(data), t:t, input:make([]byte, 520), output:make([]byte, 520), handle:WriteHandler}
+
+	err := ProcessWriteRequest(connection, ioRequest, config)
+	if err != nil {
+		t.Error(err)
+	}
+
+	uploaded, ok := backend.Load("upload.bin")
+	if !ok || !bytes.Equal(uploaded, data) {
+		t.Error("files mismatched while writing")
+	}
+}
+
+func TestProcessWriteRequestMemoryBackendAborted(t *testing.T) {
+	t.Parallel()
+
+	backend := NewMemoryBackend()
+	config := TftpConfig{timeout:time.Second, retries:3, backend:backend}
+
+	ioRequest := IORequest{isWrite:true, filename:"upload.bin", mode:"octet"}
+
+	connection := &MockConnection{file:bytes.NewReader(PatternData(512*5+256)), t:t, input:make([]byte, 520), output:make([]byte, 520), handle:DropHandler(4, WriteHandler)}
+
+	err := ProcessWriteRequest(connection, ioRequest, config)
+	if err == nil {
+		t.Error("expected the write to time out")
+	}
+
+	_, ok := backend.Load("upload.bin")
+	if ok {
+		t.Error("a write that timed out was committed")
+	}
+}
+
+func TestProcessReadRequestHandler(t *testing.T) {
+	t.Parallel()
+
+	data := PatternData(512*3+10)
+
+	handlers := NewHandlers()
+	handlers.HandleRead("pxelinux.cfg/01-*", func(request *Request) (io.Reader, int64, error) {
+		if request.Filename != "pxelinux.cfg/01-52-54-00-12-34-56" || request.RemoteAddr.String() != "127.0.0.1:6969" {
+			t.Error(fmt.Sprintf("unexpected request %+v", request))
+		}
+
+		return bytes.NewReader(data), -1, nil
+	})
+
+	config := TftpConfig{timeout:time.Second, retries:3, backend:NewMemoryBackend(), handlers:handlers}
+
+	ioRequest := IORequest{isWrite:false, filename:"./pxelinux.cfg/01-52-54-00-12-34-56", mode:"octet"}
+	ioRequest.options.Set("tsize", "0")
+
+	connection := &MockConnection{file:bytes.NewReader(data), t:t, input:make([]byte, 520), output:make([]byte, 520), handle:OAckHandler([]byte{0,4,0,0}, ReadHandler)}
+
+	err := ProcessReadRequest(connection, ioRequest, config)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// the size is unknown so tsize is not acknowledged and no oack is sent
+	if connection.writes != 4 {
+		t.Error(fmt.Sprintf("expected 4 data blocks to be sent got %d", connection.writes))
+	}
+}
+
+func TestProcessWriteRequestHandler(t *testing.T) {
+	t.Parallel()
+
+	data := PatternData(512*3+10)
+	writer := &captureWriter{}
+
+	handlers := NewHandlers()
+	handlers.HandleWrite("logs/*", func(request *Request) (io.WriteCloser, error) {
+		return writer, nil
+	})
+
+	backend := NewMemoryBackend()
+	config := TftpConfig{timeout:time.Second, retries:3, backend:backend, handlers:handlers}
+
+	ioRequest := IORequest{isWrite:true, filename:"logs/boot.log", mode:"octet"}
+
+	connection := &MockConnection{file:bytes.NewReader(data), t:t, input:make([]byte, 520), output:make([]byte, 520), handle:WriteHandler}
+
+	err := ProcessWriteRequest(connection, ioRequest, config)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !writer.closed || !bytes.Equal(writer.Bytes(), data) {
+		t.Error("expected the upload to be streamed to the handler")
+	}
+
+	if _, ok := backend.Load("logs/boot.log"); ok {
+		t.Error("an upload taken by a handler was stored by the backend")
+	}
+}
+
+func TestProcessWriteRequestNoClobber(t *testing.T) {
+	t.Parallel()
+
+	backend := NewMemoryBackend()
+	backend.Store("boot.img", []byte("production"))
+
+	config := TftpConfig{timeout:time.Second, retries:3, backend:backend, uploadPolicy:UploadNoClobber}
+
+	ioRequest := IORequest{isWrite:true, filename:"boot.img", mode:"octet"}
+
+	connection := &MockConnection{file:bytes.NewReader(PatternData(512)), t:t, input:make([]byte, 520), output:make([]byte, 520), handle:WriteHandler}
+
+	err := ProcessWriteRequest(connection, ioRequest, config)
+	if tftpErr, ok := err.(TftpError); !ok || tftpErr.errorCode != FileExistsErrorCode {
+		t.Error(fmt.Sprintf("expected a file exists error got %v", err))
+	}
+
+	if connection.writes != 0 {
+		t.Error("an upload of an existing file was acked")
+	}
+
+	data, _ := backend.Load("boot.img")
+	if string(data) != "production" {
+		t.Error("an existing file was replaced")
+	}
+}
+
+func TestProcessWriteRequestRemovesStagedFile(t *testing.T) {
+	config := TftpConfig{fsroot:t.TempDir(), fstmp:t.TempDir(), timeout:time.Second, retries:3}
+
+	ioRequest := IORequest{isWrite:true, filename:"upload.bin", mode:"octet"}
+
+	connection := &MockConnection{file:bytes.NewReader(PatternData(512*5+256)), t:t, input:make([]byte, 520), output:make([]byte, 520), handle:DropHandler(4, WriteHandler)}
+
+	err := ProcessWriteRequest(connection, ioRequest, config)
+	if err == nil {
+		t.Error("expected the write to time out")
+	}
+
+	for _, dir := range []string{config.GetFSRoot(), config.GetFSTmp()} {
+		entries, _ := os.ReadDir(dir)
+		if len(entries) != 0 {
+			t.Error(fmt.Sprintf("expected %s to be empty after a failed upload got %d entries", dir, len(entries)))
+		}
+	}
+}
+
+func TestProcessWriteRequestUploadLimit(t *testing.T) {
+	t.Parallel()
+
+	backend := NewMemoryBackend()
+	config := TftpConfig{timeout:time.Second, retries:3, backend:backend, maxUploadSize:512*3}
+
+	ioRequest := IORequest{isWrite:true, filename:"upload.bin", mode:"octet"}
+
+	connection := &MockConnection{file:bytes.NewReader(PatternData(512*5+256)), t:t, input:make([]byte, 520), output:make([]byte, 520), handle:WriteHandler}
+
+	err := ProcessWriteRequest(connection, ioRequest, config)
+	if tftpErr, ok := err.(TftpError); !ok || tftpErr.errorCode != DiskFullErrorCode {
+		t.Error(fmt.Sprintf("expected a disk full error got %v", err))
+	}
+
+	// ack 0 to ack 3, block 4 is over the limit
+	if connection.writes != 4 {
+		t.Error(fmt.Sprintf("expected 4 acks got %d", connection.writes))
+	}
+
+	if _, ok := backend.Load("upload.bin"); ok {
+		t.Error("an upload over the limit was stored")
+	}
+}
+
+func TestProcessWriteRequestQuota(t *testing.T) {
+	t.Parallel()
+
+	quotas := NewQuotas()
+	quotas.Add("127.0.0.0/8", 512*8)
+
+	backend := NewMemoryBackend()
+	config := TftpConfig{timeout:time.Second, retries:3, backend:backend, quotas:quotas}
+
+	data := PatternData(512*5+256)
+
+	for i, expected := range []int64{int64(len(data)), int64(len(data))} {
+		ioRequest := IORequest{isWrite:true, filename:fmt.Sprintf("upload%d.bin", i), mode:"octet"}
+
+		connection := &MockConnection{file:bytes.NewReader(data), t:t, input:make([]byte, 520), output:make([]byte, 520), handle:WriteHandler}
+
+		err := ProcessWriteRequest(connection, ioRequest, config)
+		if i == 1 {
+			if tftpErr, ok := err.(TftpError); !ok || tftpErr.errorCode != DiskFullErrorCode {
+				t.Error(fmt.Sprintf("expected a disk full error got %v", err))
+			}
+		} else if err != nil {
+			t.Error(err)
+		}
+
+		// the bytes of the upload over the quota are returned
+		used, _ := quotas.Used(connection.RemoteAddr())
+		if used != expected {
+			t.Error(fmt.Sprintf("upload %d: expected %d bytes used got %d", i, expected, used))
+		}
+	}
+
+	if _, ok := backend.Load("upload1.bin"); ok {
+		t.Error("an upload over the quota was stored")
+	}
+}
+
+func TestProcessWriteRequestQuotaOverwrite(t *testing.T) {
+	t.Parallel()
+
+	quotas := NewQuotas()
+	quotas.Add("127.0.0.0/8", 512*8)
+
+	config := TftpConfig{timeout:time.Second, retries:3, backend:NewMemoryBackend(), quotas:quotas}
+
+	// replacing a file only counts the bytes the upload adds
+	for i, size := range []int{512*5+256, 512*5+256, 512*7, 100} {
+		ioRequest := IORequest{isWrite:true, filename:"upload.bin", mode:"octet"}
+
+		connection := &MockConnection{file:bytes.NewReader(PatternData(size)), t:t, input:make([]byte, 520), output:make([]byte, 520), handle:WriteHandler}
+
+		err := ProcessWriteRequest(connection, ioRequest, config)
+		if err != nil {
+			t.Error(fmt.Sprintf("upload %d: %s", i, err))
+		}
+
+		used, _ := quotas.Used(connection.RemoteAddr())
+		if used != int64(size) {
+			t.Error(fmt.Sprintf("upload %d: expected %d bytes used got %d", i, size, used))
+		}
+	}
+}
+
+func TestProcessWriteRequestQuotaTransferSize(t *testing.T) {
+	t.Parallel()
+
+	quotas := NewQuotas()
+	quotas.Add("127.0.0.0/8", 1000)
+
+	backend := NewMemoryBackend()
+	backend.Store("upload.bin", PatternData(300))
+	config := TftpConfig{timeout:time.Second, retries:3, backend:backend, quotas:quotas}
+
+	// the 300 bytes of the file replaced are credited
+	ioRequest := IORequest{isWrite:true, filename:"upload.bin", mode:"octet"}
+	ioRequest.options.Set("tsize", "1301")
+
+	connection := &MockConnection{file:bytes.NewReader(PatternData(1301)), t:t, input:make([]byte, 520), output:make([]byte, 520), handle:WriteHandler}
+
+	err := ProcessWriteRequest(connection, ioRequest, config)
+	if tftpErr, ok := err.(TftpError); !ok || tftpErr.errorCode != DiskFullErrorCode {
+		t.Error(fmt.Sprintf("expected tsize over the quota to be refused with a disk full error got %v", err))
+	}
+
+	if connection.writes != 0 {
+		t.Error("expected tsize over the quota to be refused before the oack")
+	}
+
+	// the oack is answered with the first block
+	oackWriteHandler := func(t *testing.T, f io.ReaderAt, output []byte, input []byte) int {
+		if opcode, _ := ParseOpcode(output); opcode == oackOpcode {
+			output = make([]byte, 4)
+			AckToSlice(Ack{0}, output)
+		}
+
+		return WriteHandler(t, f, output, input)
+	}
+
+	ioRequest.options.Set("tsize", "1300")
+	connection = &MockConnection{file:bytes.NewReader(PatternData(1300)), t:t, input:make([]byte, 520), output:make([]byte, 520), handle:oackWriteHandler}
+
+	err = ProcessWriteRequest(connection, ioRequest, config)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if used, _ := quotas.Used(connection.RemoteAddr()); used != 1000 {
+		t.Error(fmt.Sprintf("expected 1000 bytes used got %d", used))
+	}
+}
+
+func TestProcessWriteRequestValidatorRejects(t *testing.T) {
+	t.Parallel()
+
+	hooks := NewHooks()
+	hooks.Validate(func(upload *Upload) error {
+		content, _ := io.ReadAll(upload.Content())
+		if int64(len(content)) != upload.Size || upload.Size != 512*2+10 {
+			t.Error(fmt.Sprintf("expected %d bytes of content got %d", upload.Size, len(content)))
+		}
+
+		return errors.New("checksum mismatch")
+	})
+
+	hooks.Observe(func(upload *Upload) error {
+		t.Error("a rejected upload was observed")
+		return nil
+	})
+
+	backend := NewMemoryBackend()
+	config := TftpConfig{timeout:time.Second, retries:3, backend:backend, hooks:hooks}
+
+	ioRequest := IORequest{isWrite:true, filename:"firmware.bin", mode:"octet"}
+
+	connection := &MockConnection{file:bytes.NewReader(PatternData(512*2+10)), t:t, input:make([]byte, 520), output:make([]byte, 520), handle:WriteHandler}
+
+	err := ProcessWriteRequest(connection, ioRequest, config)
+	if err == nil || err.Error() != "upload rejected: checksum mismatch" {
+		t.Error(fmt.Sprintf("expected the upload to be rejected got %v", err))
+	}
+
+	// ack 0 to ack 2, the final block is not acked
+	if connection.writes != 3 {
+		t.Error(fmt.Sprintf("expected 3 acks got %d", connection.writes))
+	}
+
+	if _, ok := backend.Load("firmware.bin"); ok {
+		t.Error("a rejected upload was stored")
+	}
+}
+
+func TestProcessWriteRequestValidatorStagedPath(t *testing.T) {
+	config := TftpConfig{fsroot:t.TempDir(), fstmp:t.TempDir(), timeout:time.Second, retries:3, hooks:NewHooks()}
+
+	err := os.WriteFile(filepath.Join(config.fsroot, "firmware.bin"), []byte("OLD"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config.hooks.Validate(CommandValidator(`test "$(cat "$TFTP_STAGED_PATH")" = NEW || { echo "staged file holds $(cat "$TFTP_STAGED_PATH")"; exit 1; }`))
+	config.hooks.Observe(func(upload *Upload) error {
+		if upload.StagedPath != "" {
+			t.Error(fmt.Sprintf("expected no staged path once committed got %s", upload.StagedPath))
+		}
+
+		return nil
+	})
+
+	ioRequest := IORequest{isWrite:true, filename:"firmware.bin", mode:"octet"}
+
+	connection := &MockConnection{file:bytes.NewReader([]byte("NEW")), t:t, input:make([]byte, 520), output:make([]byte, 520), handle:WriteHandler}
+
+	err = ProcessWriteRequest(connection, ioRequest, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := os.ReadFile(filepath.Join(config.fsroot, "firmware.bin"))
+	if err != nil || string(contents) != "NEW" {
+		t.Error(fmt.Sprintf("expected the upload to be stored got %q %v", contents, err))
+	}
+}
+
+func TestProcessWriteRequestObserver(t *testing.T) {
+	config := TftpConfig{fsroot:t.TempDir(), fstmp:t.TempDir(), timeout:time.Second, retries:3, hooks:NewHooks()}
+
+	data := PatternData(512*2+10)
+	digest := sha256.Sum256(data)
+
+	observed := []*Upload{}
+	config.hooks.Observe(func(upload *Upload) error {
+		contents, err := os.ReadFile(upload.Path)
+		if err != nil || !bytes.Equal(contents, data) {
+			t.Error("expected the upload to be stored before it is observed")
+		}
+
+		observed = append(observed, upload)
+		return nil
+	})
+
+	ioRequest := IORequest{isWrite:true, filename:"firmware.bin", mode:"octet"}
+
+	connection := &MockConnection{file:bytes.NewReader(data), t:t, input:make([]byte, 520), output:make([]byte, 520), handle:WriteHandler}
+
+	err := ProcessWriteRequest(connection, ioRequest, config)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(observed) != 1 {
+		t.Fatal(fmt.Sprintf("expected 1 observed upload got %d", len(observed)))
+	}
+
+	upload := observed[0]
+	if upload.Path != filepath.Join(config.GetFSRoot(), "firmware.bin") || upload.Size != int64(len(data)) || upload.Digest != hex.EncodeToString(digest[:]) || upload.RemoteAddr.String() != "127.0.0.1:6969" {
+		t.Error(fmt.Sprintf("unexpected upload %+v", upload))
+	}
+}
+
+func WriteHandler(t *testing.T, f io.ReaderAt, ackBytes []byte, dataBlockBytes []byte) int {
+	return BlockSizeWriteHandler(512)(t, f, ackBytes, dataBlockBytes)
+}
+
+//BlockSizeWriteHandler returns a write handler for a transfer using blockSize
+//byte data blocks.
+func BlockSizeWriteHandler(blockSize int) MockHandler {
+	return func(t *testing.T, f io.ReaderAt, ackBytes []byte, dataBlockBytes []byte) int {
+
+		ack, err := ParseAck(ackBytes)
+		if err != nil {
+			t.Error(err)
+		}
+
+		// the final block has been acked, the server dallies until a timeout
+		if int64(ack.blockNumber)*int64(blockSize) > FileSize(f) {
+			return -1
+		}
+
+		buf := make([]byte, blockSize)
+		numBytes, err:= f.ReadAt(buf, int64(ack.blockNumber)*int64(blockSize))
+		if err != nil && err != io.EOF {
+			t.Error(err)
+		}
+
+		dataBlock := DataBlock{ack.blockNumber+1, buf[:numBytes]}
+		numBytes = DataBlockToSlice(dataBlock, dataBlockBytes)
+
+		return numBytes
+	}
+}
+
+func FileSize(f io.ReaderAt) int64 {
+	if reader, ok := f.(*bytes.Reader); ok {
+		return reader.Size()
+	}
+
+	fileInfo, err := f.(*os.File).Stat()
+	if err != nil {
+		panic(err)
+	}
+
+	return fileInfo.Size()
+}
+
+func GetHash(filename string) (uint32, error) {
+	bs, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return 0, err
+	}
+	h := crc32.NewIEEE()
+	h.Write(bs)
+	return h.Sum32(), nil
+}